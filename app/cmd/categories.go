@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/azaurus1/lifevisor/internal/direct"
+	"github.com/azaurus1/lifevisor/internal/http"
+	"github.com/spf13/cobra"
+)
+
+var categoriesCmd = &cobra.Command{
+	Use:   "categories",
+	Short: "Manage the rules sorting window and browser events into categories",
+}
+
+var recomputeCategoriesCmd = &cobra.Command{
+	Use:   "recompute",
+	Short: "Store the category rules of the config and categorise every synced event again",
+	Args:  configArgs(cobra.NoArgs),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadDestinationConfig(cmd)
+		if err != nil {
+			return err
+		}
+
+		err = Recategorize(cfg)
+		if err != nil {
+			return fmt.Errorf("error recomputing categories: %w", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(categoriesCmd)
+	categoriesCmd.AddCommand(recomputeCategoriesCmd)
+	addSyncFlags(recomputeCategoriesCmd)
+}
+
+// Recategorize replaces the rules in the destination with those of cfg, if it has any, and applies them to every event
+func Recategorize(cfg syncConfig) error {
+	ctx := context.Background()
+
+	if cfg.isHTTP() {
+		return http.Recategorize(ctx, cfg.ConnString, cfg.Token, cfg.Categories)
+	}
+	return direct.Recategorize(ctx, cfg.DBType, cfg.ConnString, cfg.Categories)
+}
@@ -0,0 +1,367 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/azaurus1/lifevisor/internal/categories"
+	"github.com/azaurus1/lifevisor/internal/data"
+	"github.com/azaurus1/lifevisor/internal/deadletter"
+	"github.com/azaurus1/lifevisor/internal/device"
+	"github.com/azaurus1/lifevisor/internal/goals"
+	"github.com/azaurus1/lifevisor/internal/pipeline"
+	"github.com/azaurus1/lifevisor/internal/source"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// syncConfig is what sync and daemon need to reach the source and the destination
+type syncConfig struct {
+	DBType     string
+	SourcePath string
+	ConnString string
+	DeviceID   string
+	Interval   int // seconds between daemon syncs
+	// share of events that may fail to write before a sync returns an error
+	MaxFailureRatio float64
+	DeadLetter      string // path of the dead-letter store
+	Token           string // bearer token for lifevisor-service
+	// category rules to store in the destination, nil when the config has none
+	Categories     []data.CategoryRule
+	CategoriesFile string // ActivityWatch export whose rules follow Categories
+	// category weights to store in the destination, nil when the config has none
+	Scoring  *data.Scoring
+	TimeZone string // IANA time zone days start in, the one of this machine by default
+	// how to find sessions, nil when the config does not say
+	Sessions *data.SessionSettings
+	// limits and targets checked after every sync, and where their alerts go
+	Goals     []goals.Goal
+	Notifiers []goals.Notifier
+}
+
+// tokenEnv overrides the token of the config file, tokens are not taken as flags so they stay out of the process list
+const tokenEnv = "LIFEVISOR_TOKEN"
+
+func (c syncConfig) isHTTP() bool {
+	return strings.HasPrefix(c.ConnString, "http://") || strings.HasPrefix(c.ConnString, "https://")
+}
+
+// options are the pipeline options of a sync
+func (c syncConfig) options() pipeline.Options {
+	return pipeline.Options{
+		MaxFailureRatio: c.MaxFailureRatio,
+		DeadLetter:      deadletter.NewStore(c.DeadLetter),
+		Categories:      c.Categories,
+		Scoring:         c.Scoring,
+		Sessions:        c.Sessions,
+	}
+}
+
+// addSyncFlags registers the flags read by loadSyncConfig
+func addSyncFlags(cmd *cobra.Command) {
+	cmd.Flags().String("db-type", "", "Database type (optional)")
+	cmd.Flags().String("source-path", "", "Source path (optional)")
+	cmd.Flags().String("source", "", "Source, a SQLite file path or "+source.APIScheme+"host:port for the aw-server REST API (optional)")
+	cmd.Flags().String("conn-string", "", "Connection string (optional)")
+	cmd.Flags().String("device-id", "", "Device id to sync as, defaults to the one generated for this machine (optional)")
+	cmd.Flags().String("config", "", "Path to the configuration file (optional)")
+	cmd.Flags().Float64("max-failure-ratio", 0, "Share of events that may fail to write before a sync fails, between 0 and 1 (optional)")
+	cmd.Flags().String("dead-letter", "", "File keeping the events that failed to write, defaults to ~/.local/state/lifevisor/dead-letter.jsonl (optional)")
+	cmd.Flags().String("categories-file", "", "ActivityWatch settings or categories export to import category rules from (optional)")
+}
+
+// loadSyncConfig reads the config file first, then lets positional args and flags override it
+func loadSyncConfig(cmd *cobra.Command, args []string) (syncConfig, error) {
+	cfg, err := readSyncConfig(cmd, args)
+	if err != nil {
+		return cfg, &configError{err: err}
+	}
+	if cfg.SourcePath == "" {
+		return cfg, &configError{err: fmt.Errorf("source and connection string are required")}
+	}
+	return cfg, nil
+}
+
+// loadDestinationConfig is loadSyncConfig for commands that only write to the destination
+func loadDestinationConfig(cmd *cobra.Command) (syncConfig, error) {
+	cfg, err := readSyncConfig(cmd, nil)
+	if err != nil {
+		return cfg, &configError{err: err}
+	}
+	return cfg, nil
+}
+
+func readSyncConfig(cmd *cobra.Command, args []string) (syncConfig, error) {
+	cfg := syncConfig{Interval: 300}
+
+	configFile, _ := cmd.Flags().GetString("config")
+	if configFile != "" {
+		viper.SetConfigFile(configFile)
+		err := viper.ReadInConfig()
+		if err != nil {
+			return cfg, fmt.Errorf("error reading config: %w", err)
+		}
+
+		cfg.DBType = viper.GetString("dbType")
+		cfg.SourcePath = viper.GetString("sourcePath")
+		cfg.ConnString = viper.GetString("connString")
+		cfg.DeviceID = viper.GetString("deviceID")
+		if viper.IsSet("interval") {
+			cfg.Interval = viper.GetInt("interval")
+		}
+		cfg.MaxFailureRatio = viper.GetFloat64("maxFailureRatio")
+		cfg.DeadLetter = viper.GetString("deadLetter")
+		cfg.Token = viper.GetString("token")
+		if viper.IsSet("categories") {
+			cfg.Categories = []data.CategoryRule{}
+			err = viper.UnmarshalKey("categories", &cfg.Categories)
+			if err != nil {
+				return cfg, fmt.Errorf("error reading categories: %w", err)
+			}
+		}
+		cfg.CategoriesFile = viper.GetString("categoriesFile")
+		if viper.IsSet("scores") {
+			cfg.Scoring, err = readScoring()
+			if err != nil {
+				return cfg, err
+			}
+		}
+		cfg.TimeZone = viper.GetString("timezone")
+		if viper.IsSet("sessions") {
+			cfg.Sessions, err = readSessionSettings()
+			if err != nil {
+				return cfg, err
+			}
+		}
+		cfg.Goals, cfg.Notifiers, err = readGoals()
+		if err != nil {
+			return cfg, err
+		}
+	}
+
+	if len(args) >= 3 {
+		cfg.DBType = args[0]
+		cfg.SourcePath = args[1]
+		cfg.ConnString = args[2]
+	}
+
+	override := func(name string, value *string) {
+		if flag, _ := cmd.Flags().GetString(name); flag != "" {
+			*value = flag
+		}
+	}
+	override("db-type", &cfg.DBType)
+	override("source-path", &cfg.SourcePath)
+	override("source", &cfg.SourcePath)
+	override("conn-string", &cfg.ConnString)
+	override("device-id", &cfg.DeviceID)
+	override("dead-letter", &cfg.DeadLetter)
+	override("categories-file", &cfg.CategoriesFile)
+	if token := os.Getenv(tokenEnv); token != "" {
+		cfg.Token = token
+	}
+	if cmd.Flags().Changed("max-failure-ratio") {
+		cfg.MaxFailureRatio, _ = cmd.Flags().GetFloat64("max-failure-ratio")
+	}
+
+	if cfg.ConnString == "" {
+		return cfg, fmt.Errorf("source and connection string are required")
+	}
+	if cfg.MaxFailureRatio < 0 || cfg.MaxFailureRatio > 1 {
+		return cfg, fmt.Errorf("max failure ratio must be between 0 and 1, got %v", cfg.MaxFailureRatio)
+	}
+
+	if cfg.CategoriesFile != "" {
+		imported, err := categories.ImportActivityWatch(cfg.CategoriesFile)
+		if err != nil {
+			return cfg, fmt.Errorf("error importing categories: %w", err)
+		}
+		cfg.Categories = append(append([]data.CategoryRule{}, cfg.Categories...), imported...)
+	}
+	for i, rule := range cfg.Categories {
+		if rule.Category == "" || rule.Regex == "" {
+			return cfg, fmt.Errorf("category rule %d needs both a category and a regex", i+1)
+		}
+	}
+
+	if cfg.TimeZone == "" {
+		cfg.TimeZone = localTimeZone()
+	}
+	if _, err := time.LoadLocation(cfg.TimeZone); err != nil {
+		return cfg, fmt.Errorf("invalid time zone %q: %w", cfg.TimeZone, err)
+	}
+	if cfg.Scoring != nil {
+		cfg.Scoring.TimeZone = cfg.TimeZone
+	}
+
+	// Identify this machine in the destination
+	deviceID, err := device.Resolve(cfg.DeviceID)
+	if err != nil {
+		return cfg, fmt.Errorf("error resolving device id: %w", err)
+	}
+	cfg.DeviceID = deviceID
+
+	if cfg.DeadLetter == "" {
+		cfg.DeadLetter, err = deadletter.DefaultPath()
+		if err != nil {
+			return cfg, fmt.Errorf("error locating the dead-letter store: %w", err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// readScoring reads the category weights of the config file. They are a list rather than a map
+// because viper lowercases map keys, and categories are case sensitive.
+func readScoring() (*data.Scoring, error) {
+	var scores []struct {
+		Category string
+		Weight   float64
+	}
+	err := viper.UnmarshalKey("scores", &scores)
+	if err != nil {
+		return nil, fmt.Errorf("error reading scores: %w", err)
+	}
+
+	scoring := &data.Scoring{Weights: make(map[string]float64, len(scores))}
+	for _, score := range scores {
+		if score.Category == "" {
+			return nil, fmt.Errorf("every score needs a category")
+		}
+		if score.Weight < -1 || score.Weight > 1 {
+			return nil, fmt.Errorf("weight of %q must be between -1 and 1, got %v", score.Category, score.Weight)
+		}
+		if _, ok := scoring.Weights[score.Category]; ok {
+			return nil, fmt.Errorf("category %q is weighted twice", score.Category)
+		}
+		scoring.Weights[score.Category] = score.Weight
+	}
+
+	return scoring, nil
+}
+
+// readSessionSettings reads the sessions section of the config file, filling in the defaults
+func readSessionSettings() (*data.SessionSettings, error) {
+	settings := struct {
+		GroupBy       string
+		MinMinutes    int
+		MaxGapSeconds int
+	}{GroupBy: "category", MinMinutes: 25, MaxGapSeconds: 120}
+	err := viper.UnmarshalKey("sessions", &settings)
+	if err != nil {
+		return nil, fmt.Errorf("error reading sessions: %w", err)
+	}
+
+	if settings.GroupBy != "category" && settings.GroupBy != "app" {
+		return nil, fmt.Errorf("sessions can be grouped by category or app, not %q", settings.GroupBy)
+	}
+	if settings.MinMinutes < 1 {
+		return nil, fmt.Errorf("sessions must last at least a minute, got %d", settings.MinMinutes)
+	}
+	if settings.MaxGapSeconds < 0 {
+		return nil, fmt.Errorf("the gap within sessions cannot be negative, got %d", settings.MaxGapSeconds)
+	}
+
+	return &data.SessionSettings{
+		GroupBy:       settings.GroupBy,
+		MinSeconds:    settings.MinMinutes * 60,
+		MaxGapSeconds: settings.MaxGapSeconds,
+	}, nil
+}
+
+// readGoals reads the goals and notifiers of the config file, alerts are printed when it names no notifiers
+func readGoals() ([]goals.Goal, []goals.Notifier, error) {
+	var entries []struct {
+		Name     string
+		App      string
+		Site     string
+		Category string
+		Max      time.Duration
+		Min      time.Duration
+	}
+	err := viper.UnmarshalKey("goals", &entries)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading goals: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil, nil, nil
+	}
+
+	list := make([]goals.Goal, 0, len(entries))
+	names := make(map[string]bool, len(entries))
+	for i, entry := range entries {
+		goal := goals.Goal{Name: entry.Name, Max: entry.Max, Min: entry.Min}
+		for kind, target := range map[string]string{"app": entry.App, "site": entry.Site, "category": entry.Category} {
+			if target == "" {
+				continue
+			}
+			if goal.Kind != "" {
+				return nil, nil, fmt.Errorf("goal %d names more than one of app, site and category", i+1)
+			}
+			goal.Kind, goal.Target = kind, target
+		}
+		if goal.Name == "" {
+			goal.Name = goal.Kind + " " + goal.Target
+		}
+		if err := goal.Validate(); err != nil {
+			return nil, nil, err
+		}
+		if names[goal.Name] {
+			return nil, nil, fmt.Errorf("goal %q is defined twice, give one a name", goal.Name)
+		}
+		names[goal.Name] = true
+		list = append(list, goal)
+	}
+
+	var notifierEntries []struct {
+		Type    string
+		Command string
+		URL     string
+	}
+	err = viper.UnmarshalKey("notifiers", &notifierEntries)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading notifiers: %w", err)
+	}
+
+	var notifiers []goals.Notifier
+	for i, entry := range notifierEntries {
+		switch entry.Type {
+		case "stdout":
+			notifiers = append(notifiers, &goals.Stdout{W: os.Stdout})
+		case "command":
+			args := strings.Fields(entry.Command)
+			if len(args) == 0 {
+				args = []string{"notify-send"}
+			}
+			notifiers = append(notifiers, &goals.Command{Args: args})
+		case "webhook":
+			if entry.URL == "" {
+				return nil, nil, fmt.Errorf("webhook notifier %d needs a url", i+1)
+			}
+			notifiers = append(notifiers, goals.NewWebhook(entry.URL))
+		default:
+			return nil, nil, fmt.Errorf("notifier %d has unknown type %q, expected stdout, command or webhook", i+1, entry.Type)
+		}
+	}
+	if len(notifiers) == 0 {
+		notifiers = append(notifiers, &goals.Stdout{W: os.Stdout})
+	}
+
+	return list, notifiers, nil
+}
+
+// localTimeZone is the IANA name of the time zone of this machine, or UTC when it cannot tell
+func localTimeZone() string {
+	if tz := os.Getenv("TZ"); tz != "" {
+		return strings.TrimPrefix(tz, ":")
+	}
+	// /etc/localtime links into the zoneinfo database on Linux and macOS
+	if target, err := os.Readlink("/etc/localtime"); err == nil {
+		if _, name, ok := strings.Cut(target, "zoneinfo/"); ok {
+			return name
+		}
+	}
+	return "UTC"
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// useConfig loads yaml as the config file for one test
+func useConfig(t *testing.T, yaml string) {
+	t.Helper()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.SetConfigType("yaml")
+	if err := viper.ReadConfig(strings.NewReader(yaml)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadGoals(t *testing.T) {
+	useConfig(t, `
+goals:
+  - name: reddit
+    site: reddit.com
+    max: 30m
+  - category: Work
+    min: 4h
+notifiers:
+  - type: command
+  - type: webhook
+    url: http://localhost:9000/alerts
+`)
+
+	list, notifiers, err := readGoals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || len(notifiers) != 2 {
+		t.Fatalf("got %d goals and %d notifiers, want 2 and 2", len(list), len(notifiers))
+	}
+	if g := list[0]; g.Name != "reddit" || g.Kind != "site" || g.Target != "reddit.com" || g.Max != 30*time.Minute {
+		t.Errorf("first goal = %+v", g)
+	}
+	if g := list[1]; g.Name != "category Work" || g.Kind != "category" || g.Min != 4*time.Hour {
+		t.Errorf("unnamed goal = %+v", g)
+	}
+}
+
+func TestReadGoalsRejectsMalformedEntries(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{"two kinds", `
+goals:
+  - app: Slack
+    site: slack.com
+    max: 1h
+`, "more than one of app, site and category"},
+		{"no kind", `
+goals:
+  - name: idle
+    max: 1h
+`, ""},
+		{"no bound", `
+goals:
+  - app: Slack
+`, ""},
+		{"both bounds", `
+goals:
+  - app: Slack
+    max: 1h
+    min: 10m
+`, ""},
+		{"bad duration", `
+goals:
+  - app: Slack
+    max: an hour
+`, "error reading goals"},
+		{"duplicate name", `
+goals:
+  - app: Slack
+    max: 1h
+  - app: Slack
+    max: 2h
+`, "defined twice"},
+		{"webhook without url", `
+goals:
+  - app: Slack
+    max: 1h
+notifiers:
+  - type: webhook
+`, "needs a url"},
+		{"unknown notifier", `
+goals:
+  - app: Slack
+    max: 1h
+notifiers:
+  - type: pager
+`, "unknown type"},
+	}
+
+	for _, test := range tests {
+		useConfig(t, test.yaml)
+		_, _, err := readGoals()
+		if err == nil {
+			t.Errorf("%s: readGoals accepted it", test.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: error %q, want it to mention %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestReadGoalsDefaultsToStdout(t *testing.T) {
+	useConfig(t, `
+goals:
+  - app: Slack
+    max: 1h
+`)
+
+	_, notifiers, err := readGoals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notifiers) != 1 {
+		t.Errorf("got %d notifiers, want the stdout one", len(notifiers))
+	}
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// first retry delay after a failed sync, doubled on every consecutive failure
+	daemonBaseBackoff = 30 * time.Second
+	daemonMaxBackoff  = 30 * time.Minute
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon [dbtype] [source-path] [connection-string]",
+	Short: "Keep syncing activity watch data on a schedule",
+	Args:  configArgs(argCounts(0, 3)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadSyncConfig(cmd, args)
+		if err != nil {
+			return err
+		}
+		if interval, _ := cmd.Flags().GetInt("interval"); interval > 0 {
+			cfg.Interval = interval
+		}
+		if cfg.Interval <= 0 {
+			return &configError{err: fmt.Errorf("interval must be a positive number of seconds, got %d", cfg.Interval)}
+		}
+
+		err = Daemon(cfg)
+		if err != nil {
+			return fmt.Errorf("error running daemon: %w", err)
+		}
+		return nil
+	},
+}
+
+var daemonInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Install the daemon as a systemd user unit",
+	Args:  configArgs(cobra.NoArgs),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		configFile, _ := cmd.Flags().GetString("config")
+
+		unitPath, err := installSystemdUnit(configFile)
+		if err != nil {
+			return fmt.Errorf("error installing systemd unit: %w", err)
+		}
+
+		fmt.Printf("Wrote %s\n", unitPath)
+		fmt.Println("Enable it with: systemctl --user daemon-reload && systemctl --user enable --now lifevisor.service")
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+	addSyncFlags(daemonCmd)
+	daemonCmd.Flags().Int("interval", 0, "Seconds between syncs, defaults to 300 (optional)")
+
+	daemonCmd.AddCommand(daemonInstallCmd)
+	daemonInstallCmd.Flags().String("config", "", "Path to the configuration file the unit runs with")
+	daemonInstallCmd.MarkFlagRequired("config")
+}
+
+// Daemon syncs every cfg.Interval seconds until SIGINT or SIGTERM, which stop the sync in flight
+// once the batch being written and its checkpoint are done
+func Daemon(cfg syncConfig) error {
+	if cfg.Interval <= 0 {
+		return fmt.Errorf("interval must be a positive number of seconds, got %d", cfg.Interval)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	s, err := newSyncer(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	interval := time.Duration(cfg.Interval) * time.Second
+	log.Printf("Syncing every %v", interval)
+
+	var failures int
+	for {
+		// the pipeline stops between batches when ctx is done, so a signal never interrupts a batch half way
+		syncCtx, cancel := context.WithTimeout(ctx, syncTimeout)
+		err := s.Sync(syncCtx)
+		cancel()
+		if ctx.Err() != nil {
+			log.Println("Stopping daemon")
+			return nil
+		}
+
+		var wait time.Duration
+		if err != nil {
+			failures++
+			wait = retryDelay(failures)
+			log.Printf("Sync failed (%d in a row), retrying in %v: %v", failures, wait, err)
+		} else {
+			failures = 0
+			wait = withJitter(interval)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("Stopping daemon")
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// retryDelay is the wait after the given number of consecutive failed syncs, doubling from
+// daemonBaseBackoff up to daemonMaxBackoff without ever overflowing
+func retryDelay(failures int) time.Duration {
+	wait := daemonBaseBackoff
+	for i := 1; i < failures && wait < daemonMaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, daemonMaxBackoff)
+}
+
+// withJitter spreads the delay by up to 10% so several machines don't sync in lockstep
+func withJitter(d time.Duration) time.Duration {
+	spread := int64(d) / 10
+	if spread <= 0 {
+		return d
+	}
+	return d + time.Duration(rand.Int63n(2*spread)-spread)
+}
+
+const systemdUnit = `[Unit]
+Description=lifevisor ActivityWatch sync
+After=network-online.target
+
+[Service]
+ExecStart=%s daemon --config %s
+Restart=on-failure
+RestartSec=30
+
+[Install]
+WantedBy=default.target
+`
+
+// installSystemdUnit writes a user unit running this binary with configFile
+func installSystemdUnit(configFile string) (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+
+	configFile, err = filepath.Abs(configFile)
+	if err != nil {
+		return "", err
+	}
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	unitPath := filepath.Join(configDir, "systemd", "user", "lifevisor.service")
+
+	err = os.MkdirAll(filepath.Dir(unitPath), 0o755)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.WriteFile(unitPath, []byte(fmt.Sprintf(systemdUnit, executable, configFile)), 0o644)
+	if err != nil {
+		return "", err
+	}
+
+	return unitPath, nil
+}
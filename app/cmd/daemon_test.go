@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{6, 16 * time.Minute},
+		{7, daemonMaxBackoff},
+		{64, daemonMaxBackoff},
+		{1000, daemonMaxBackoff},
+	}
+	for _, test := range tests {
+		if got := retryDelay(test.failures); got != test.want {
+			t.Errorf("retryDelay(%d) = %v, want %v", test.failures, got, test.want)
+		}
+	}
+}
+
+func TestRetryDelayNeverShrinks(t *testing.T) {
+	previous := time.Duration(0)
+	for failures := 1; failures <= 200; failures++ {
+		wait := retryDelay(failures)
+		if wait < previous || wait <= 0 || wait > daemonMaxBackoff {
+			t.Fatalf("retryDelay(%d) = %v after %v", failures, wait, previous)
+		}
+		previous = wait
+	}
+}
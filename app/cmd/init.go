@@ -2,59 +2,69 @@ package cmd
 
 import (
 	"context"
-	"log"
+	"fmt"
 	"strconv"
-	"strings"
-	"time"
 
+	"github.com/azaurus1/lifevisor/internal/deadletter"
 	"github.com/azaurus1/lifevisor/internal/direct"
 	lifevisorHttp "github.com/azaurus1/lifevisor/internal/http"
+	"github.com/azaurus1/lifevisor/internal/pipeline"
 	"github.com/spf13/cobra"
 )
 
 var initCmd = &cobra.Command{
-	Use:   "init [dbtype] [source-path] [connection-string] [concurrency]",
+	Use:   "init [dbtype] [source] [connection-string] [batch-size]",
 	Short: "Run initial load of data to the specified database type",
-	Args:  cobra.ExactArgs(4), // Four arguments: dbtype, source-path, connection-string, concurrency
-	Run: func(cmd *cobra.Command, args []string) {
-		dbType := args[0]
-		sourcePath := args[1]
-		connString := args[2]
+	Args:  configArgs(cobra.ExactArgs(4)), // Four arguments: dbtype, source, connection-string, batch-size
+	RunE: func(cmd *cobra.Command, args []string) error {
+		batchSize, err := strconv.Atoi(args[3])
+		if err != nil || batchSize < 1 {
+			return &configError{err: fmt.Errorf("batch size must be a positive integer: %s", args[3])}
+		}
 
-		concurrency, err := strconv.Atoi(args[3])
+		// the token, device id and dead-letter store are resolved like those of sync
+		cfg, err := loadSyncConfig(cmd, args[:3])
 		if err != nil {
-			log.Fatal("error converting concurrency to int: ", err)
+			return err
 		}
 
-		// Determine the connection type based on the connString
-		var isHTTP bool
-		if strings.HasPrefix(connString, "http://") || strings.HasPrefix(connString, "https://") {
-			isHTTP = true
+		resume, _ := cmd.Flags().GetBool("resume")
+		opts := pipeline.Options{
+			BatchSize:       batchSize,
+			Progress:        true,
+			MaxFailureRatio: cfg.MaxFailureRatio,
+			DeadLetter:      deadletter.NewStore(cfg.DeadLetter),
 		}
 
 		// Call the Initialize method
-		err = Initialisation(dbType, sourcePath, connString, concurrency, isHTTP)
+		err = Initialisation(cfg.DBType, cfg.SourcePath, cfg.ConnString, cfg.Token, cfg.DeviceID, cfg.isHTTP(), resume, opts)
 		if err != nil {
-			cmd.PrintErrln("Error during initialization:", err)
+			return fmt.Errorf("error during initialization: %w", err)
 		}
+		return nil
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(initCmd)
+	initCmd.Flags().Bool("resume", false, "Continue from the checkpoints of an interrupted init instead of starting over (optional)")
+	initCmd.Flags().String("device-id", "", "Device id to sync as, defaults to the one generated for this machine (optional)")
+	initCmd.Flags().Float64("max-failure-ratio", 0, "Share of events that may fail to write before init exits with an error, between 0 and 1 (optional)")
+	initCmd.Flags().String("dead-letter", "", "File keeping the events that failed to write, defaults to ~/.local/state/lifevisor/dead-letter.jsonl (optional)")
+	initCmd.Flags().String("config", "", "Path to the configuration file, for the token and the settings not given as arguments (optional)")
 }
 
-func Initialisation(dbType, sqlitePath, connString string, concurrency int, isHTTP bool) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+func Initialisation(dbType, sourcePath, connString, token, deviceID string, isHTTP, resume bool, opts pipeline.Options) error {
+	// no deadline, reading a whole history from the source can take a while
+	ctx := context.Background()
 
 	if isHTTP {
-		err := lifevisorHttp.HttpInitialisation(ctx, sqlitePath, connString, concurrency)
+		err := lifevisorHttp.HttpInitialisation(ctx, sourcePath, connString, token, deviceID, resume, opts)
 		if err != nil {
 			return err
 		}
 	} else {
-		err := direct.DirectInitialisation(ctx, dbType, sqlitePath, connString, concurrency)
+		err := direct.DirectInitialisation(ctx, dbType, sourcePath, connString, deviceID, resume, opts)
 		if err != nil {
 			return err
 		}
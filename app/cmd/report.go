@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/azaurus1/lifevisor/internal/data"
+	"github.com/azaurus1/lifevisor/internal/direct"
+	"github.com/azaurus1/lifevisor/internal/http"
+	"github.com/spf13/cobra"
+)
+
+var reportCmd = &cobra.Command{
+	Use:       "report today|week",
+	Short:     "Print the active time, score, top apps and sites and longest focus streak of today or the last seven days",
+	ValidArgs: []string{"today", "week"},
+	Args:      periodArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadDestinationConfig(cmd)
+		if err != nil {
+			return err
+		}
+		top, _ := cmd.Flags().GetInt("top")
+		if top < 1 {
+			return &configError{err: fmt.Errorf("top must be a positive integer, got %d", top)}
+		}
+
+		start, end := period(cfg, args[0])
+		report, err := Report(cfg, start, end, top)
+		if err != nil {
+			return fmt.Errorf("error reading report: %w", err)
+		}
+
+		printReport(cmd.OutOrStdout(), report, args[0] == "week")
+		return nil
+	},
+}
+
+var reportSwitchesCmd = &cobra.Command{
+	Use:       "switches [today|week]",
+	Short:     "Print the app switches per hour and the most frequent transitions of today or the last seven days",
+	ValidArgs: []string{"today", "week"},
+	Args:      configArgs(cobra.MaximumNArgs(1), cobra.OnlyValidArgs),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadDestinationConfig(cmd)
+		if err != nil {
+			return err
+		}
+		top, _ := cmd.Flags().GetInt("top")
+		if top < 1 {
+			return &configError{err: fmt.Errorf("top must be a positive integer, got %d", top)}
+		}
+
+		name := "today"
+		if len(args) > 0 {
+			name = args[0]
+		}
+		start, end := period(cfg, name)
+		report, err := Switches(cfg, start, end, top)
+		if err != nil {
+			return fmt.Errorf("error reading switches: %w", err)
+		}
+
+		printSwitches(cmd.OutOrStdout(), report)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+	addSyncFlags(reportCmd)
+	reportCmd.Flags().Int("top", 5, "Number of apps and sites to list (optional)")
+
+	reportCmd.AddCommand(reportSwitchesCmd)
+	addSyncFlags(reportSwitchesCmd)
+	reportSwitchesCmd.Flags().Int("top", 10, "Number of transitions to list (optional)")
+}
+
+// periodArgs accepts the single today or week argument of report and sessions
+var periodArgs = configArgs(cobra.ExactArgs(1), cobra.OnlyValidArgs)
+
+// period is today or the last seven days up to now, starting at midnight in the time zone of cfg
+func period(cfg syncConfig, name string) (time.Time, time.Time) {
+	// loading the config checked the time zone already
+	location, _ := time.LoadLocation(cfg.TimeZone)
+	now := time.Now().In(location)
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	if name == "week" {
+		start = start.AddDate(0, 0, -6)
+	}
+	return start, now
+}
+
+func Report(cfg syncConfig, start, end time.Time, top int) (data.Report, error) {
+	ctx := context.Background()
+
+	if cfg.isHTTP() {
+		return http.Report(ctx, cfg.ConnString, cfg.Token, start, end, top)
+	}
+	return direct.Report(ctx, cfg.DBType, cfg.ConnString, start, end, top)
+}
+
+func Switches(cfg syncConfig, start, end time.Time, top int) (data.SwitchReport, error) {
+	ctx := context.Background()
+
+	if cfg.isHTTP() {
+		return http.Switches(ctx, cfg.ConnString, cfg.Token, start, end, top)
+	}
+	return direct.Switches(ctx, cfg.DBType, cfg.ConnString, start, end, top)
+}
+
+func printReport(w io.Writer, report data.Report, perDay bool) {
+	total := report.Total()
+
+	fmt.Fprintf(w, "%s to %s\n\n", report.Start.Format("Mon 2 Jan 15:04"), report.End.Format("Mon 2 Jan 15:04"))
+	fmt.Fprintf(w, "  Active         %s\n", formatSeconds(total.Active))
+	fmt.Fprintf(w, "  Productive     %s%s\n", formatSeconds(total.Productive), share(total.Productive, total.Active))
+	fmt.Fprintf(w, "  Distracting    %s%s\n", formatSeconds(total.Distracting), share(total.Distracting, total.Active))
+	fmt.Fprintf(w, "  Score          %.0f\n", total.Score)
+	if report.LongestStreak != nil {
+		fmt.Fprintf(w, "  Longest focus  %s from %s\n", formatSeconds(report.LongestStreak.Duration),
+			report.LongestStreak.Start.In(report.Start.Location()).Format("Mon 15:04"))
+	}
+
+	if perDay && len(report.Days) > 0 {
+		fmt.Fprintf(w, "\nDays\n")
+		for _, day := range report.Days {
+			fmt.Fprintf(w, "  %s  %-9s score %.0f\n", day.Day, formatSeconds(day.Active), day.Score)
+		}
+	}
+
+	printUsage(w, "Top apps", report.TopApps)
+	printUsage(w, "Top sites", report.TopSites)
+}
+
+func printUsage(w io.Writer, title string, usage []data.Usage) {
+	if len(usage) == 0 {
+		return
+	}
+
+	width := 0
+	for _, u := range usage {
+		width = max(width, len(u.Key))
+	}
+
+	fmt.Fprintf(w, "\n%s\n", title)
+	for _, u := range usage {
+		fmt.Fprintf(w, "  %-*s  %s\n", width, u.Key, formatSeconds(u.Duration))
+	}
+}
+
+// formatSeconds prints a duration in hours and minutes, or seconds when it is under a minute
+func formatSeconds(seconds float64) string {
+	d := time.Duration(seconds) * time.Second
+	switch {
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh %02dm", int(d.Hours()), int(d.Minutes())%60)
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	default:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	}
+}
+
+func share(part, whole float64) string {
+	if whole == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%.0f%%)", 100*part/whole)
+}
+
+func printSwitches(w io.Writer, report data.SwitchReport) {
+	total := report.Total()
+
+	fmt.Fprintf(w, "%s to %s\n\n", report.Start.Format("Mon 2 Jan 15:04"), report.End.Format("Mon 2 Jan 15:04"))
+	fmt.Fprintf(w, "  App switches        %d\n", total.Switches)
+	fmt.Fprintf(w, "  Per active hour     %.1f\n", total.PerHour())
+	fmt.Fprintf(w, "  Mean time between   %s\n", formatSeconds(total.MeanInterval()))
+
+	if len(report.Hours) > 0 {
+		fmt.Fprintf(w, "\nBy hour of the day\n")
+		for _, hour := range report.Hours {
+			fmt.Fprintf(w, "  %02d:00  %5d switches  %5.1f per active hour\n", hour.Hour, hour.Switches, hour.PerHour())
+		}
+	}
+
+	if len(report.Transitions) > 0 {
+		width := 0
+		for _, transition := range report.Transitions {
+			width = max(width, len(transition.From)+len(transition.To)+4)
+		}
+
+		fmt.Fprintf(w, "\nMost frequent switches\n")
+		for _, transition := range report.Transitions {
+			fmt.Fprintf(w, "  %-*s  %d\n", width, transition.From+" -> "+transition.To, transition.Switches)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/azaurus1/lifevisor/internal/deadletter"
+	"github.com/azaurus1/lifevisor/internal/direct"
+	"github.com/azaurus1/lifevisor/internal/http"
+	"github.com/spf13/cobra"
+)
+
+var retryFailedCmd = &cobra.Command{
+	Use:   "retry-failed",
+	Short: "Replay the buckets and events that failed to upload",
+	Args:  configArgs(cobra.NoArgs),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadDestinationConfig(cmd)
+		if err != nil {
+			return err
+		}
+
+		err = RetryFailed(cfg)
+		if err != nil {
+			return fmt.Errorf("error retrying failed uploads: %w", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(retryFailedCmd)
+	addSyncFlags(retryFailedCmd)
+}
+
+func RetryFailed(cfg syncConfig) error {
+	ctx := context.Background()
+	store := deadletter.NewStore(cfg.DeadLetter)
+
+	if cfg.isHTTP() {
+		return http.RetryFailed(ctx, cfg.ConnString, cfg.Token, cfg.DeviceID, store)
+	}
+	return direct.RetryFailed(ctx, cfg.DBType, cfg.ConnString, cfg.DeviceID, store)
+}
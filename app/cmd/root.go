@@ -1,23 +1,98 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 
+	"github.com/azaurus1/lifevisor/internal/pipeline"
 	"github.com/spf13/cobra"
 )
 
+// Exit codes, so cron and monitoring can tell what to look at
+const (
+	exitFailure     = 1
+	exitConfig      = 2 // bad arguments, flags or config file
+	exitSource      = 3 // ActivityWatch could not be read
+	exitDestination = 4 // PostgreSQL or lifevisor-service could not be written to
+)
+
 var rootCmd = &cobra.Command{
 	Use:   "lifevisor",
 	Short: "lifevisor is a cli tool for syncing local acitivitywatch data to a remote postgres db",
 	Run: func(cmd *cobra.Command, args []string) {
 
 	},
+	// errors are printed once by Execute
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func init() {
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return &configError{err: err}
+	})
+}
+
+// configError is a mistake in the arguments, flags or config file
+type configError struct {
+	err error
+}
+
+func (e *configError) Error() string { return e.err.Error() }
+func (e *configError) Unwrap() error { return e.err }
+
+// configArgs runs every validator on the positional arguments, failing as a configError
+func configArgs(validators ...cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		err := cobra.MatchAll(validators...)(cmd, args)
+		if err != nil {
+			return &configError{err: err}
+		}
+		return nil
+	}
+}
+
+// argCounts accepts any of counts positional arguments, e.g. none when a config file names them all
+func argCounts(counts ...int) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if slices.Contains(counts, len(args)) {
+			return nil
+		}
+		accepted := make([]string, len(counts))
+		for i, n := range counts {
+			accepted[i] = strconv.Itoa(n)
+		}
+		last := len(accepted) - 1
+		if last > 0 {
+			accepted = append(accepted[:last-1], accepted[last-1]+" or "+accepted[last])
+		}
+		return fmt.Errorf("accepts %s arg(s), received %d", strings.Join(accepted, ", "), len(args))
+	}
+}
+
+func exitCode(err error) int {
+	var cfgErr *configError
+	var srcErr *pipeline.SourceError
+	var destErr *pipeline.DestinationError
+	switch {
+	case errors.As(err, &cfgErr):
+		return exitConfig
+	case errors.As(err, &srcErr):
+		return exitSource
+	case errors.As(err, &destErr):
+		return exitDestination
+	default:
+		return exitFailure
+	}
 }
 
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "Oops. An error while executing lifevisor '%s'\n", err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
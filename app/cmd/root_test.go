@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSyncArgs(t *testing.T) {
+	tests := []struct {
+		args  []string
+		valid bool
+	}{
+		{nil, true},
+		{[]string{"postgres"}, false},
+		{[]string{"postgres", "aw.db"}, false},
+		{[]string{"postgres", "aw.db", "postgres://localhost/lifevisor"}, true},
+		{[]string{"postgres", "aw.db", "postgres://localhost/lifevisor", "300"}, true},
+		{[]string{"postgres", "aw.db", "postgres://localhost/lifevisor", "300", "extra"}, false},
+	}
+
+	for _, test := range tests {
+		err := syncCmd.Args(syncCmd, test.args)
+		if test.valid {
+			if err != nil {
+				t.Errorf("%d args: %v", len(test.args), err)
+			}
+			continue
+		}
+		if exitCode(err) != exitConfig {
+			t.Errorf("%d args: exit code %d, want %d", len(test.args), exitCode(err), exitConfig)
+		}
+		var cfgErr *configError
+		if !errors.As(err, &cfgErr) || !strings.Contains(err.Error(), "accepts 0, 3 or 4 arg(s), received") {
+			t.Errorf("%d args: error %v", len(test.args), err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/azaurus1/lifevisor/internal/data"
+	"github.com/azaurus1/lifevisor/internal/direct"
+	"github.com/azaurus1/lifevisor/internal/http"
+	"github.com/spf13/cobra"
+)
+
+var sessionsCmd = &cobra.Command{
+	Use:       "sessions today|week",
+	Short:     "List the focus sessions of today or the last seven days and count them per day",
+	ValidArgs: []string{"today", "week"},
+	Args:      periodArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadDestinationConfig(cmd)
+		if err != nil {
+			return err
+		}
+		minimum, _ := cmd.Flags().GetDuration("min")
+
+		start, end := period(cfg, args[0])
+		sessions, err := ListSessions(cfg, start, end)
+		if err != nil {
+			return fmt.Errorf("error reading sessions: %w", err)
+		}
+
+		var long []data.Session
+		for _, session := range sessions {
+			if session.End.Sub(session.Start) >= minimum {
+				long = append(long, session)
+			}
+		}
+
+		location, _ := time.LoadLocation(cfg.TimeZone)
+		printSessions(cmd.OutOrStdout(), long, location)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sessionsCmd)
+	addSyncFlags(sessionsCmd)
+	sessionsCmd.Flags().Duration("min", 0, "Only list sessions at least this long, e.g. 45m (optional)")
+}
+
+func ListSessions(cfg syncConfig, start, end time.Time) ([]data.Session, error) {
+	ctx := context.Background()
+
+	if cfg.isHTTP() {
+		return http.ListSessions(ctx, cfg.ConnString, cfg.Token, start, end)
+	}
+	return direct.ListSessions(ctx, cfg.DBType, cfg.ConnString, start, end)
+}
+
+// printSessions lists sessions under the day they started on in location, with a count per day
+func printSessions(w io.Writer, sessions []data.Session, location *time.Location) {
+	if len(sessions) == 0 {
+		fmt.Fprintln(w, "No sessions")
+		return
+	}
+
+	var day string
+	for i, session := range sessions {
+		start := session.Start.In(location)
+		if start.Format(time.DateOnly) != day {
+			day = start.Format(time.DateOnly)
+
+			var count, uninterrupted int
+			var focused float64
+			for _, other := range sessions[i:] {
+				if other.Start.In(location).Format(time.DateOnly) != day {
+					break
+				}
+				count++
+				focused += other.Focused
+				if other.Interruptions == 0 {
+					uninterrupted++
+				}
+			}
+
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "%s  %d sessions, %s focused, %d uninterrupted\n", start.Format("Mon 2 Jan"), count, formatSeconds(focused), uninterrupted)
+		}
+
+		fmt.Fprintf(w, "  %s-%s  %-8s %s, %d interruptions\n",
+			start.Format("15:04"), session.End.In(location).Format("15:04"),
+			formatSeconds(session.End.Sub(session.Start).Seconds()), session.Key, session.Interruptions)
+	}
+}
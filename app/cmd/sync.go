@@ -2,88 +2,125 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"log"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/azaurus1/lifevisor/internal/direct"
+	"github.com/azaurus1/lifevisor/internal/goals"
 	"github.com/azaurus1/lifevisor/internal/http"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 // this will be the scheduled task
 
 var syncCmd = &cobra.Command{
-	Use:   "sync [dbtype] [source-path] [connection-string] [interval]",
-	Short: "Sync activity watch data by interval",
-	Args:  cobra.MaximumNArgs(4), // Four arguments: dbtype, source-path, connection-string, interval
-	Run: func(cmd *cobra.Command, args []string) {
-		var dbType string
-		var sourcePath string
-		var connString string
-		var intervalString string
-
-		// Config
-		configFile, _ := cmd.Flags().GetString("config")
-		if configFile != "" {
-			// using config
-			viper.SetConfigFile(configFile)
-			err := viper.ReadInConfig()
-			if err != nil {
-				log.Println("error reading config: ", err)
-			}
-
-			dbType = viper.GetString("dbType")
-			sourcePath = viper.GetString("sourcePath")
-			connString = viper.GetString("connString")
-			intervalString = viper.GetString("interval")
-		}
-
-		interval, err := strconv.Atoi(intervalString)
+	Use:   "sync [dbtype] [source-path] [connection-string]",
+	Short: "Sync activity watch data since the last synced event",
+	Args:  configArgs(argCounts(0, 3, 4)), // None with a config file, or dbtype, source-path, connection-string and an ignored legacy interval
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadSyncConfig(cmd, args)
 		if err != nil {
-			log.Fatal("cannot convert interval to int: ", err)
+			return err
 		}
 
-		// Determine the connection type based on the connString
-		var isHTTP bool
-		if strings.HasPrefix(connString, "http://") || strings.HasPrefix(connString, "https://") {
-			isHTTP = true
+		// Keep syncing on changes to the source
+		if watch, _ := cmd.Flags().GetBool("watch"); watch {
+			debounce, _ := cmd.Flags().GetDuration("debounce")
+			err = Watch(cfg, debounce)
+			if err != nil {
+				return fmt.Errorf("error during watch: %w", err)
+			}
+			return nil
 		}
 
 		// Call the Sync method
-		err = Sync(dbType, sourcePath, connString, interval, isHTTP)
+		err = Sync(cfg)
 		if err != nil {
-			cmd.PrintErrln("Error during sync:", err)
+			return fmt.Errorf("error during sync: %w", err)
 		}
+		return nil
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(syncCmd)
-	syncCmd.Flags().String("db-type", "", "Database type (optional)")
-	syncCmd.Flags().String("source-path", "", "Source path (optional)")
-	syncCmd.Flags().String("conn-string", "", "Connection string (optional)")
-	syncCmd.Flags().String("interval", "", "Sync Interval (optional)")
-	syncCmd.Flags().String("config", "", "Path to the configuration file (optional)")
+	addSyncFlags(syncCmd)
+	syncCmd.Flags().Bool("watch", false, "Keep running and sync whenever ActivityWatch writes to the source (optional)")
+	syncCmd.Flags().Duration("debounce", 2*time.Second, "How long writes must settle before a watch sync (optional)")
 }
 
-func Sync(dbType, sourcePath, connString string, interval int, isHTTP bool) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// syncer is a sync target that stays open between runs and reads goal usage from its destination
+type syncer interface {
+	Sync(ctx context.Context) error
+	Close() error
+	goals.UsageReader
+}
 
-	if isHTTP {
-		err := http.HttpSync(ctx, sourcePath, connString, interval)
-		if err != nil {
-			return err
-		}
+// newSyncer opens the source and the destination described by cfg, checking the goals of cfg after every sync
+func newSyncer(ctx context.Context, cfg syncConfig) (syncer, error) {
+	var s syncer
+	var err error
+	if cfg.isHTTP() {
+		s, err = http.NewSyncer(cfg.SourcePath, cfg.ConnString, cfg.Token, cfg.DeviceID, cfg.options())
 	} else {
-		err := direct.DirectSync(ctx, dbType, sourcePath, connString, interval)
-		if err != nil {
-			return err
-		}
+		s, err = direct.NewSyncer(ctx, cfg.DBType, cfg.SourcePath, cfg.ConnString, cfg.DeviceID, cfg.options())
+	}
+	if err != nil || len(cfg.Goals) == 0 {
+		return s, err
 	}
 
+	statePath, err := goals.DefaultStatePath()
+	if err != nil {
+		s.Close()
+		return nil, &configError{err: fmt.Errorf("error locating the goal state: %w", err)}
+	}
+	// loading the config checked the time zone already
+	location, _ := time.LoadLocation(cfg.TimeZone)
+
+	return &goalSyncer{
+		syncer: s,
+		checker: &goals.Checker{
+			Goals:     cfg.Goals,
+			Notifiers: cfg.Notifiers,
+			State:     goals.NewState(statePath),
+			Location:  location,
+		},
+	}, nil
+}
+
+// goalSyncer checks the goals against the destination after every successful sync
+type goalSyncer struct {
+	syncer
+	checker *goals.Checker
+}
+
+func (s *goalSyncer) Sync(ctx context.Context) error {
+	err := s.syncer.Sync(ctx)
+	if err != nil {
+		return err
+	}
+
+	// a failed check leaves the synced data alone
+	if err := s.checker.Check(ctx, s.syncer); err != nil {
+		log.Printf("Error checking goals: %v", err)
+	}
 	return nil
 }
+
+// syncTimeout bounds a single sync, generous enough for a slow ActivityWatch API and short
+// enough that cron runs don't pile up
+const syncTimeout = 5 * time.Minute
+
+func Sync(cfg syncConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), syncTimeout)
+	defer cancel()
+
+	s, err := newSyncer(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	return s.Sync(ctx)
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/azaurus1/lifevisor/internal/source"
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch syncs whenever ActivityWatch writes to the SQLite database, waiting for writes
+// to settle for debounce so a burst of heartbeats results in a single sync
+func Watch(cfg syncConfig, debounce time.Duration) error {
+	if strings.HasPrefix(cfg.SourcePath, source.APIScheme) {
+		return fmt.Errorf("--watch needs a SQLite source, use the daemon to poll the ActivityWatch API")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	s, err := newSyncer(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	// watch the directory, SQLite recreates the -wal file on checkpoints
+	sourcePath, err := filepath.Abs(cfg.SourcePath)
+	if err != nil {
+		return err
+	}
+	err = watcher.Add(filepath.Dir(sourcePath))
+	if err != nil {
+		return err
+	}
+	watched := map[string]bool{
+		sourcePath:          true,
+		sourcePath + "-wal": true,
+	}
+
+	// catch up before waiting for changes
+	watchSync(ctx, s)
+
+	log.Printf("Watching %s for changes", sourcePath)
+
+	writes := debouncer{quiet: debounce, maxDelay: watchMaxDelay * debounce}
+	var pending <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Stopping watch")
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !watched[event.Name] || !event.Has(fsnotify.Write|fsnotify.Create) {
+				continue
+			}
+			// restart the quiet period on every write, up to the max delay
+			pending = time.After(writes.write(time.Now()))
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("Error watching source: %v", err)
+		case <-pending:
+			pending = nil
+			writes.synced()
+			watchSync(ctx, s)
+		}
+	}
+}
+
+// watchMaxDelay bounds how many debounce periods a stream of writes can put a sync off by,
+// aw-server writes its database every few seconds while it is tracking
+const watchMaxDelay = 4
+
+// debouncer tells how long to wait before syncing after a write: until writes have settled
+// for quiet, but no later than maxDelay after the first write not synced yet
+type debouncer struct {
+	quiet    time.Duration
+	maxDelay time.Duration
+	first    time.Time // zero when every write is synced
+}
+
+// write records a write at now and returns the delay before the sync
+func (d *debouncer) write(now time.Time) time.Duration {
+	if d.first.IsZero() {
+		d.first = now
+	}
+	return max(min(d.quiet, d.first.Add(d.maxDelay).Sub(now)), 0)
+}
+
+// synced forgets the writes so far, the sync about to run reads them
+func (d *debouncer) synced() {
+	d.first = time.Time{}
+}
+
+// watchSync runs one sync, a stop signal ends it between batches like in the daemon
+func watchSync(ctx context.Context, s syncer) {
+	syncCtx, cancel := context.WithTimeout(ctx, syncTimeout)
+	defer cancel()
+
+	if err := s.Sync(syncCtx); err != nil && ctx.Err() == nil {
+		log.Printf("Sync failed: %v", err)
+	}
+}
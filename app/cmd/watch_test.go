@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDebouncerSettles(t *testing.T) {
+	d := debouncer{quiet: 2 * time.Second, maxDelay: 8 * time.Second}
+	start := time.Date(2024, 12, 13, 10, 0, 0, 0, time.UTC)
+
+	if wait := d.write(start); wait != 2*time.Second {
+		t.Errorf("first write waits %v, want the quiet period", wait)
+	}
+	if wait := d.write(start.Add(time.Second)); wait != 2*time.Second {
+		t.Errorf("second write waits %v, want the quiet period again", wait)
+	}
+}
+
+func TestDebouncerSyncsUnderConstantWrites(t *testing.T) {
+	d := debouncer{quiet: 2 * time.Second, maxDelay: 8 * time.Second}
+	start := time.Date(2024, 12, 13, 10, 0, 0, 0, time.UTC)
+
+	// a write every half second never lets the quiet period pass, the sync is due 8s after the first
+	var due time.Time
+	synced := false
+	for now := start; now.Before(start.Add(20 * time.Second)); now = now.Add(500 * time.Millisecond) {
+		if !due.IsZero() && !now.Before(due) {
+			if now.Sub(start) > 8*time.Second+500*time.Millisecond {
+				t.Fatalf("synced at %v after the first write, want at most 8s", now.Sub(start))
+			}
+			synced = true
+			break
+		}
+		due = now.Add(d.write(now))
+	}
+	if !synced {
+		t.Fatal("writes faster than the quiet period put the sync off for good")
+	}
+
+	// after a sync the next write starts a fresh max delay
+	d.synced()
+	later := start.Add(time.Minute)
+	if wait := d.write(later); wait != 2*time.Second {
+		t.Errorf("write after a sync waits %v, want the quiet period", wait)
+	}
+}
+
+func TestDebouncerNeverNegative(t *testing.T) {
+	d := debouncer{quiet: 2 * time.Second, maxDelay: 8 * time.Second}
+	start := time.Date(2024, 12, 13, 10, 0, 0, 0, time.UTC)
+
+	d.write(start)
+	if wait := d.write(start.Add(time.Minute)); wait != 0 {
+		t.Errorf("write long past the max delay waits %v, want 0", wait)
+	}
+}
@@ -0,0 +1,64 @@
+package categories
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/azaurus1/lifevisor/internal/data"
+)
+
+// class is a category as ActivityWatch exports it, either in the "classes" of the settings
+// export or in the "categories" of the categorisation export
+type class struct {
+	Name []string
+	Rule struct {
+		Type       string
+		Regex      string
+		IgnoreCase bool `json:"ignore_case"`
+	}
+}
+
+// ImportActivityWatch reads the category rules of an ActivityWatch settings or categories export.
+// Categories without a regex rule only group their children and are left out.
+func ImportActivityWatch(path string) ([]data.CategoryRule, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var export struct {
+		Classes    []class
+		Categories []class
+	}
+	err = json.Unmarshal(content, &export)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	classes := export.Classes
+	if classes == nil {
+		classes = export.Categories
+	}
+	if classes == nil {
+		return nil, fmt.Errorf("%s: no classes or categories found, is it an ActivityWatch export?", path)
+	}
+
+	rules := []data.CategoryRule{}
+	for _, c := range classes {
+		if c.Rule.Type != "regex" || c.Rule.Regex == "" {
+			continue
+		}
+		if len(c.Name) == 0 {
+			return nil, fmt.Errorf("%s: category with regex %q has no name", path, c.Rule.Regex)
+		}
+		rules = append(rules, data.CategoryRule{
+			Category:   strings.Join(c.Name, " > "),
+			Regex:      c.Rule.Regex,
+			IgnoreCase: c.Rule.IgnoreCase,
+		})
+	}
+
+	return rules, nil
+}
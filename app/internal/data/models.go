@@ -2,8 +2,6 @@ package data
 
 import (
 	"time"
-
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Models struct {
@@ -12,6 +10,7 @@ type Models struct {
 }
 
 type Bucket struct {
+	DeviceID string
 	Key      int
 	ID       string
 	Created  time.Time
@@ -22,6 +21,7 @@ type Bucket struct {
 }
 
 type Event struct {
+	DeviceID  string
 	ID        int
 	BucketID  int
 	Timestamp time.Time
@@ -29,7 +29,172 @@ type Event struct {
 	DataStr   string
 }
 
-func New(conn *pgxpool.Pool) *Models {
-	repo = NewPostgresRepository(conn)
-	return &Models{}
+// WriteResult counts what an upsert did with the events it was given, the rest were already up to date
+type WriteResult struct {
+	Inserted int
+	Updated  int
+}
+
+func (r *WriteResult) count(inserted bool) {
+	if inserted {
+		r.Inserted++
+	} else {
+		r.Updated++
+	}
+}
+
+// CategoryRule puts the window and tab events whose app, title or url match Regex into Category,
+// a path like "Work > Programming". When several rules match the deepest category wins.
+type CategoryRule struct {
+	Category   string
+	Regex      string // PostgreSQL regular expression
+	IgnoreCase bool
+}
+
+// Scoring weighs the active window time of categories from -1 (distracting) to 1 (productive),
+// categories without a weight take the one of their closest ancestor and 0 without any
+type Scoring struct {
+	Weights  map[string]float64
+	TimeZone string // IANA time zone days start at midnight in
+}
+
+// DailyScore is the active window time of a day and how productive it was
+type DailyScore struct {
+	Day         string // YYYY-MM-DD
+	Active      float64
+	Productive  float64 // seconds in categories weighted above 0
+	Distracting float64 // seconds in categories weighted below 0
+	Weighted    float64 // sum of seconds times weight
+	Score       float64 // 0 all distracting, 50 neutral, 100 all productive
+}
+
+// Usage is the active time spent on an app or site
+type Usage struct {
+	Key      string
+	Duration float64
+}
+
+// FocusStreak is the longest stretch of productive time
+type FocusStreak struct {
+	Start    time.Time
+	Duration float64
+}
+
+// Report is what lifevisor report prints for a range of days
+type Report struct {
+	Start         time.Time
+	End           time.Time
+	Days          []DailyScore
+	TopApps       []Usage
+	TopSites      []Usage
+	LongestStreak *FocusStreak // nil without productive time
+}
+
+// Total adds up the days of the report, scoring them together
+func (r Report) Total() DailyScore {
+	var total DailyScore
+	for _, day := range r.Days {
+		total.Active += day.Active
+		total.Productive += day.Productive
+		total.Distracting += day.Distracting
+		total.Weighted += day.Weighted
+	}
+	total.Score = 50
+	if total.Active > 0 {
+		total.Score = 50 + 50*total.Weighted/total.Active
+	}
+	return total
+}
+
+// SessionSettings say how sessions are found: runs of active window time of one category or
+// app (GroupBy) lasting at least MinSeconds and broken by nothing as long as MaxGapSeconds
+type SessionSettings struct {
+	GroupBy       string // category or app
+	MinSeconds    int
+	MaxGapSeconds int
+}
+
+// Session is a stretch of time on one device dominated by a category or app
+type Session struct {
+	DeviceID      string
+	Start         time.Time
+	End           time.Time
+	Key           string  // the dominant category or app
+	Focused       float64 // seconds spent on Key
+	Interruptions int     // windows of something else activated in between
+}
+
+// HourlySwitches are the app switches and active window time in one hour of the day
+type HourlySwitches struct {
+	Hour     int // 0 to 23
+	Switches int
+	Active   float64
+}
+
+// Transition counts the switches from one app to another
+type Transition struct {
+	From     string
+	To       string
+	Switches int
+}
+
+// SwitchReport is how often the focused app changed in a range of time
+type SwitchReport struct {
+	Start       time.Time
+	End         time.Time
+	Hours       []HourlySwitches
+	Transitions []Transition // most frequent first
+}
+
+// Total adds up the switches and active time of every hour
+func (r SwitchReport) Total() HourlySwitches {
+	var total HourlySwitches
+	for _, hour := range r.Hours {
+		total.Switches += hour.Switches
+		total.Active += hour.Active
+	}
+	return total
+}
+
+// PerHour is the number of switches per hour of active time
+func (h HourlySwitches) PerHour() float64 {
+	if h.Active == 0 {
+		return 0
+	}
+	return float64(h.Switches) / h.Active * 3600
+}
+
+// MeanInterval is the mean active time in seconds between two switches
+func (h HourlySwitches) MeanInterval() float64 {
+	if h.Switches == 0 {
+		return h.Active
+	}
+	return h.Active / float64(h.Switches)
+}
+
+// SyncState is the high-water mark of a bucket in the destination
+type SyncState struct {
+	DeviceID      string
+	BucketKey     int
+	LastEventID   int
+	LastTimestamp time.Time
+}
+
+// NextSyncStates returns the watermarks after pushing events, for the buckets that moved
+func NextSyncStates(states map[int]SyncState, events []Event) map[int]SyncState {
+	next := make(map[int]SyncState)
+	for _, event := range events {
+		state, ok := next[event.BucketID]
+		if !ok {
+			state = states[event.BucketID]
+			state.DeviceID = event.DeviceID
+			state.BucketKey = event.BucketID
+		}
+		if event.ID > state.LastEventID {
+			state.LastEventID = event.ID
+			state.LastTimestamp = event.Timestamp
+		}
+		next[event.BucketID] = state
+	}
+	return next
 }
@@ -2,15 +2,43 @@ package data
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"log"
+	"strconv"
+	"time"
 
+	"github.com/azaurus1/lifevisor/migrations"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jackc/pgx/v5/stdlib"
 	migrate "github.com/rubenv/sql-migrate"
 )
 
+// DefaultTenant owns everything written directly to PostgreSQL, see ConnectAsTenant
+const DefaultTenant = 1
+
+// ConnectAsTenant opens a pool whose connections read and write as tenantID, inserts take
+// the tenant from the session and row-level security hides the rows of other tenants
+func ConnectAsTenant(ctx context.Context, connString string, tenantID int) (*pgxpool.Pool, error) {
+	config, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		return nil, err
+	}
+
+	config.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		_, err := conn.Exec(ctx, `select set_config('lifevisor.tenant_id', $1, false)`, strconv.Itoa(tenantID))
+		return err
+	}
+
+	return pgxpool.NewWithConfig(ctx, config)
+}
+
 func (u *PostgresRepository) RunMigrations() error {
-	migrations := &migrate.FileMigrationSource{
-		Dir: "./migrations", // Path to migration files
+	source := &migrate.EmbedFileSystemMigrationSource{
+		FileSystem: migrations.FS, // Migration files embedded in the binary
+		Root:       ".",
 	}
 
 	// Get connection string from the pool config
@@ -23,7 +51,7 @@ func (u *PostgresRepository) RunMigrations() error {
 	defer dbSQL.Close()
 
 	// Run migrations using the sql.DB connection
-	n, err := migrate.Exec(dbSQL, "postgres", migrations, migrate.Up)
+	n, err := migrate.Exec(dbSQL, "postgres", source, migrate.Up)
 	if err != nil {
 		log.Println("Error running migrations: ", err)
 		return err
@@ -33,27 +61,422 @@ func (u *PostgresRepository) RunMigrations() error {
 	return nil
 }
 
-func (u *PostgresRepository) InsertBucket(bucket Bucket) error {
-	ctx := context.Background()
+// heartbeats keep growing the duration of the latest event, so refresh rows that changed since the last push
+const upsertEventStmt = `insert into eventmodel (device_id, id, bucket_id, timestamp, duration, datastr) values ($1, $2, $3, $4, $5, $6)
+	on conflict (tenant_id, device_id, id) do update set timestamp = excluded.timestamp, duration = excluded.duration, datastr = excluded.datastr
+	where (eventmodel.timestamp, eventmodel.duration, eventmodel.datastr::text) is distinct from (excluded.timestamp, excluded.duration, excluded.datastr::text)`
 
-	stmt := `insert into bucketmodel (key, id, created, name, type, client, hostname) values ($1, $2, $3, $4, $5, $6, $7) on conflict (key) do nothing`
-	_, err := u.Conn.Exec(ctx, stmt, bucket.Key, bucket.ID, bucket.Created, bucket.Name, bucket.Type, bucket.Client, bucket.Hostname)
+// InsertBuckets inserts the buckets in one transaction
+func (u *PostgresRepository) InsertBuckets(ctx context.Context, buckets []Bucket) error {
+	tx, err := u.Conn.Begin(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(ctx)
 
-	return nil
+	stmt := `insert into bucketmodel (device_id, key, id, created, name, type, client, hostname) values ($1, $2, $3, $4, $5, $6, $7, $8) on conflict (tenant_id, device_id, key) do nothing`
+	for _, bucket := range buckets {
+		_, err := tx.Exec(ctx, stmt, bucket.DeviceID, bucket.Key, bucket.ID, bucket.Created, bucket.Name, bucket.Type, bucket.Client, bucket.Hostname)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Batches with at least this many events are bulk copied, smaller ones are upserted row by row
+const copyThreshold = 1000
+
+// InsertEvents writes the events in one transaction, through a staging table for large batches
+func (u *PostgresRepository) InsertEvents(ctx context.Context, events []Event) (WriteResult, error) {
+	var result WriteResult
+
+	tx, err := u.Conn.Begin(ctx)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback(ctx)
+
+	if len(events) < copyThreshold {
+		batch := &pgx.Batch{}
+		for _, event := range events {
+			batch.Queue(upsertEventStmt+` returning (xmax = 0)`, event.DeviceID, event.ID, event.BucketID, event.Timestamp, event.Duration, event.DataStr)
+		}
 
+		results := tx.SendBatch(ctx, batch)
+		for range events {
+			// no row comes back when the event was unchanged
+			var inserted bool
+			err := results.QueryRow().Scan(&inserted)
+			if errors.Is(err, pgx.ErrNoRows) {
+				continue
+			}
+			if err != nil {
+				results.Close()
+				return result, err
+			}
+			result.count(inserted)
+		}
+		err = results.Close()
+		if err != nil {
+			return result, err
+		}
+
+		return result, tx.Commit(ctx)
+	}
+
+	_, err = tx.Exec(ctx, `create temp table eventmodel_staging (like eventmodel including defaults) on commit drop`)
+	if err != nil {
+		return result, err
+	}
+
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"eventmodel_staging"}, []string{"device_id", "id", "bucket_id", "timestamp", "duration", "datastr"},
+		pgx.CopyFromSlice(len(events), func(i int) ([]any, error) {
+			event := events[i]
+			return []any{event.DeviceID, event.ID, event.BucketID, event.Timestamp, event.Duration, event.DataStr}, nil
+		}))
+	if err != nil {
+		return result, err
+	}
+
+	// same merge as InsertEvent, distinct on the key since one batch may carry an event twice
+	stmt := `insert into eventmodel (device_id, id, bucket_id, timestamp, duration, datastr)
+	select distinct on (device_id, id) device_id, id, bucket_id, timestamp, duration, datastr from eventmodel_staging order by device_id, id
+	on conflict (tenant_id, device_id, id) do update set timestamp = excluded.timestamp, duration = excluded.duration, datastr = excluded.datastr
+	where (eventmodel.timestamp, eventmodel.duration, eventmodel.datastr::text) is distinct from (excluded.timestamp, excluded.duration, excluded.datastr::text)
+	returning (xmax = 0)`
+	rows, err := tx.Query(ctx, stmt)
+	if err != nil {
+		return result, err
+	}
+
+	// xmax is only set on rows that already existed, unchanged rows are not returned at all
+	for rows.Next() {
+		var inserted bool
+		err := rows.Scan(&inserted)
+		if err != nil {
+			rows.Close()
+			return result, err
+		}
+		result.count(inserted)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	return result, tx.Commit(ctx)
 }
 
-func (u *PostgresRepository) InsertEvent(event Event) error {
-	ctx := context.Background()
+func (u *PostgresRepository) GetSyncStates(ctx context.Context, deviceID string) (map[int]SyncState, error) {
+	rows, err := u.Conn.Query(ctx, `select device_id, bucket_key, last_event_id, last_timestamp from syncstate where tenant_id = lifevisor_tenant() and device_id = $1`, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := make(map[int]SyncState)
+	for rows.Next() {
+		var state SyncState
+		err := rows.Scan(&state.DeviceID, &state.BucketKey, &state.LastEventID, &state.LastTimestamp)
+		if err != nil {
+			return nil, err
+		}
+		states[state.BucketKey] = state
+	}
+
+	return states, rows.Err()
+}
 
-	stmt := `insert into eventmodel (id, bucket_id, timestamp, duration, datastr) values ($1, $2, $3, $4, $5) on conflict (id) do nothing`
-	_, err := u.Conn.Exec(ctx, stmt, event.ID, event.BucketID, event.Timestamp, event.Duration, event.DataStr)
+func (u *PostgresRepository) UpdateSyncState(ctx context.Context, state SyncState) error {
+	stmt := `insert into syncstate (device_id, bucket_key, last_event_id, last_timestamp, updated) values ($1, $2, $3, $4, now())
+	on conflict (tenant_id, device_id, bucket_key) do update set last_event_id = excluded.last_event_id, last_timestamp = excluded.last_timestamp, updated = now()
+	where syncstate.last_event_id <= excluded.last_event_id`
+	_, err := u.Conn.Exec(ctx, stmt, state.DeviceID, state.BucketKey, state.LastEventID, state.LastTimestamp)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// RefreshActiveEvents recomputes the active time of the events written since the last refresh
+func (u *PostgresRepository) RefreshActiveEvents(ctx context.Context) (int, error) {
+	var n int
+	err := u.Conn.QueryRow(ctx, `select refresh_active_events()`).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// SetCategoryRules replaces the categorisation rules, reporting whether they changed.
+// Changed rules mark every event for categorising again on the next refresh.
+func (u *PostgresRepository) SetCategoryRules(ctx context.Context, rules []CategoryRule) (bool, error) {
+	if rules == nil {
+		rules = []CategoryRule{}
+	}
+	payload, err := json.Marshal(rules)
+	if err != nil {
+		return false, err
+	}
+
+	var changed bool
+	err = u.Conn.QueryRow(ctx, `select set_category_rules($1::jsonb)`, string(payload)).Scan(&changed)
+	if err != nil {
+		return false, err
+	}
+
+	return changed, nil
+}
+
+// RefreshEventCategories categorises the events written since the last refresh
+func (u *PostgresRepository) RefreshEventCategories(ctx context.Context) (int, error) {
+	var n int
+	err := u.Conn.QueryRow(ctx, `select refresh_event_categories()`).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// RecategorizeEvents categorises every event again with the current rules
+func (u *PostgresRepository) RecategorizeEvents(ctx context.Context) (int, error) {
+	var n int
+	err := u.Conn.QueryRow(ctx, `select recategorize_events()`).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// SetScoring replaces the category weights and the time zone of daily_scores, reporting whether
+// they changed. Changes score every day again on the next refresh.
+func (u *PostgresRepository) SetScoring(ctx context.Context, scoring Scoring) (bool, error) {
+	weights := scoring.Weights
+	if weights == nil {
+		weights = map[string]float64{}
+	}
+	payload, err := json.Marshal(weights)
+	if err != nil {
+		return false, err
+	}
+
+	var changed bool
+	err = u.Conn.QueryRow(ctx, `select set_scoring($1::jsonb, $2)`, string(payload), scoring.TimeZone).Scan(&changed)
+	if err != nil {
+		return false, err
+	}
+
+	return changed, nil
+}
+
+// RefreshDailyScores scores the days whose active time or categories changed since the last refresh
+func (u *PostgresRepository) RefreshDailyScores(ctx context.Context) (int, error) {
+	var n int
+	err := u.Conn.QueryRow(ctx, `select refresh_daily_scores()`).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// execer is a pool, a connection or a transaction
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// refresh runs the refresh functions named in order through q, catching up on the derived
+// tables clients have not refreshed before reading them
+func refresh(ctx context.Context, q execer, functions ...string) error {
+	for _, function := range functions {
+		_, err := q.Exec(ctx, `select `+function+`()`)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Report scores the days from start to end, dates taken in the location of start, and lists the
+// top apps and sites in between
+func (u *PostgresRepository) Report(ctx context.Context, start, end time.Time, top int) (Report, error) {
+	report := Report{Start: start, End: end}
+
+	// 1. catch up
+	err := refresh(ctx, u.Conn, "refresh_active_events", "refresh_event_categories", "refresh_daily_scores")
+	if err != nil {
+		return report, err
+	}
+
+	// 2. the days, end is exclusive
+	lastDay := end.Add(-time.Nanosecond).In(start.Location())
+	rows, err := u.Conn.Query(ctx, `select day::text, active_seconds, productive_seconds, distracting_seconds, weighted_seconds, score
+	from daily_scores where tenant_id = lifevisor_tenant() and day between $1::date and $2::date order by day`,
+		start.Format(time.DateOnly), lastDay.Format(time.DateOnly))
+	if err != nil {
+		return report, err
+	}
+	report.Days, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (DailyScore, error) {
+		var day DailyScore
+		err := row.Scan(&day.Day, &day.Active, &day.Productive, &day.Distracting, &day.Weighted, &day.Score)
+		return day, err
+	})
+	if err != nil {
+		return report, err
+	}
+
+	// 3. top apps and sites
+	topActive := func(bucketType string) ([]Usage, error) {
+		rows, err := u.Conn.Query(ctx, `select key, seconds from top_active($1, $2, $3, $4)`, bucketType, start, end, top)
+		if err != nil {
+			return nil, err
+		}
+		return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Usage, error) {
+			var usage Usage
+			err := row.Scan(&usage.Key, &usage.Duration)
+			return usage, err
+		})
+	}
+	report.TopApps, err = topActive("currentwindow")
+	if err != nil {
+		return report, err
+	}
+	report.TopSites, err = topActive("web.tab.current")
+	if err != nil {
+		return report, err
+	}
+
+	// 4. longest streak
+	var streak FocusStreak
+	err = u.Conn.QueryRow(ctx, `select started, seconds from longest_focus_streak($1, $2)`, start, end).Scan(&streak.Start, &streak.Duration)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return report, err
+	}
+	if err == nil {
+		report.LongestStreak = &streak
+	}
+
+	return report, nil
+}
+
+// SetSessionSettings replaces how sessions are found, reporting whether that changed.
+// Changes find every session again on the next refresh.
+func (u *PostgresRepository) SetSessionSettings(ctx context.Context, settings SessionSettings) (bool, error) {
+	var changed bool
+	err := u.Conn.QueryRow(ctx, `select set_session_settings($1, $2, $3)`, settings.GroupBy, settings.MinSeconds, settings.MaxGapSeconds).Scan(&changed)
+	if err != nil {
+		return false, err
+	}
+
+	return changed, nil
+}
+
+// RefreshSessions finds the sessions around the activity changed since the last refresh
+func (u *PostgresRepository) RefreshSessions(ctx context.Context) (int, error) {
+	var n int
+	err := u.Conn.QueryRow(ctx, `select refresh_sessions()`).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// ListSessions returns the sessions overlapping start to end, oldest first
+func (u *PostgresRepository) ListSessions(ctx context.Context, start, end time.Time) ([]Session, error) {
+	err := refresh(ctx, u.Conn, "refresh_active_events", "refresh_event_categories", "refresh_sessions")
+	if err != nil {
+		return nil, err
+	}
+
+	// sessions.started is UTC
+	rows, err := u.Conn.Query(ctx, `select device_id, started, ended, key, focused_seconds, interruptions
+	from sessions where tenant_id = lifevisor_tenant()
+	and started < ($2::timestamptz at time zone 'UTC') and ended > ($1::timestamptz at time zone 'UTC')
+	order by started, device_id`, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Session, error) {
+		var session Session
+		err := row.Scan(&session.DeviceID, &session.Start, &session.End, &session.Key, &session.Focused, &session.Interruptions)
+		return session, err
+	})
+}
+
+// RefreshAppSwitches finds the app switches among the window events written since the last refresh
+func (u *PostgresRepository) RefreshAppSwitches(ctx context.Context) (int, error) {
+	var n int
+	err := u.Conn.QueryRow(ctx, `select refresh_app_switches()`).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// Switches counts the app switches from start to end per hour of the day in the location of
+// start, and lists the top most frequent transitions
+func (u *PostgresRepository) Switches(ctx context.Context, start, end time.Time, top int) (SwitchReport, error) {
+	report := SwitchReport{Start: start, End: end}
+
+	// 1. catch up
+	err := refresh(ctx, u.Conn, "refresh_active_events", "refresh_app_switches")
+	if err != nil {
+		return report, err
+	}
+
+	// 2. per hour of the day
+	rows, err := u.Conn.Query(ctx, `select hour, switches, active_seconds from switches_by_hour($1, $2, $3)`, start, end, start.Location().String())
+	if err != nil {
+		return report, err
+	}
+	report.Hours, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (HourlySwitches, error) {
+		var hour HourlySwitches
+		err := row.Scan(&hour.Hour, &hour.Switches, &hour.Active)
+		return hour, err
+	})
+	if err != nil {
+		return report, err
+	}
+
+	// 3. transitions
+	rows, err = u.Conn.Query(ctx, `select from_app, to_app, switches from top_transitions($1, $2, $3)`, start, end, top)
+	if err != nil {
+		return report, err
+	}
+	report.Transitions, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (Transition, error) {
+		var transition Transition
+		err := row.Scan(&transition.From, &transition.To, &transition.Switches)
+		return transition, err
+	})
+	if err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+// GoalUsage returns the active seconds from start to end spent on target, an app, site or
+// category depending on kind
+func (u *PostgresRepository) GoalUsage(ctx context.Context, kind, target string, start, end time.Time) (float64, error) {
+	err := refresh(ctx, u.Conn, "refresh_active_events", "refresh_event_categories")
+	if err != nil {
+		return 0, err
+	}
+
+	var seconds float64
+	err = u.Conn.QueryRow(ctx, `select goal_usage($1, $2, $3, $4)`, kind, target, start, end).Scan(&seconds)
+	if err != nil {
+		return 0, err
+	}
+
+	return seconds, nil
+}
@@ -1,17 +1,33 @@
 package data
 
 import (
+	"context"
+	"time"
+
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Repository interface {
 	RunMigrations() error
-	InsertBucket(bucket Bucket) error
-	InsertEvent(event Event) error
+	InsertBuckets(ctx context.Context, buckets []Bucket) error
+	InsertEvents(ctx context.Context, events []Event) (WriteResult, error)
+	GetSyncStates(ctx context.Context, deviceID string) (map[int]SyncState, error)
+	UpdateSyncState(ctx context.Context, state SyncState) error
+	RefreshActiveEvents(ctx context.Context) (int, error)
+	SetCategoryRules(ctx context.Context, rules []CategoryRule) (bool, error)
+	RefreshEventCategories(ctx context.Context) (int, error)
+	RecategorizeEvents(ctx context.Context) (int, error)
+	SetScoring(ctx context.Context, scoring Scoring) (bool, error)
+	RefreshDailyScores(ctx context.Context) (int, error)
+	Report(ctx context.Context, start, end time.Time, top int) (Report, error)
+	SetSessionSettings(ctx context.Context, settings SessionSettings) (bool, error)
+	RefreshSessions(ctx context.Context) (int, error)
+	ListSessions(ctx context.Context, start, end time.Time) ([]Session, error)
+	RefreshAppSwitches(ctx context.Context) (int, error)
+	Switches(ctx context.Context, start, end time.Time, top int) (SwitchReport, error)
+	GoalUsage(ctx context.Context, kind, target string, start, end time.Time) (float64, error)
 }
 
-var repo Repository
-
 type PostgresRepository struct {
 	Conn *pgxpool.Pool
 }
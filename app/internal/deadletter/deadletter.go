@@ -0,0 +1,265 @@
+package deadletter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/azaurus1/lifevisor/internal/data"
+)
+
+// Entry is a bucket or an event the destination did not accept, exactly one of Bucket and Event is set
+type Entry struct {
+	Bucket      *data.Bucket `json:",omitempty"`
+	Event       *data.Event  `json:",omitempty"`
+	Error       string
+	Attempts    int
+	FirstFailed time.Time
+	LastFailed  time.Time
+}
+
+// DeviceID returns the device the entry was synced from
+func (e Entry) DeviceID() string {
+	if e.Bucket != nil {
+		return e.Bucket.DeviceID
+	}
+	return e.Event.DeviceID
+}
+
+// key identifies the row an entry is for, so failing it again adds to the attempts of the entry
+type key struct {
+	deviceID string
+	bucket   bool
+	bucketID int
+	id       int
+}
+
+func (e Entry) key() key {
+	if e.Bucket != nil {
+		return key{deviceID: e.Bucket.DeviceID, bucket: true, bucketID: e.Bucket.Key}
+	}
+	return key{deviceID: e.Event.DeviceID, bucketID: e.Event.BucketID, id: e.Event.ID}
+}
+
+// Store keeps the entries as JSON lines in a file
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+// DefaultPath is dead-letter.jsonl in the lifevisor directory of $XDG_STATE_HOME, ~/.local/state by default
+func DefaultPath() (string, error) {
+	stateDir := os.Getenv("XDG_STATE_HOME")
+	if stateDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		stateDir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(stateDir, "lifevisor", "dead-letter.jsonl"), nil
+}
+
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+func (s *Store) Path() string {
+	return s.path
+}
+
+// AddBuckets records that buckets failed to write with cause
+func (s *Store) AddBuckets(buckets []data.Bucket, cause error) error {
+	entries := make([]Entry, len(buckets))
+	for i := range buckets {
+		entries[i] = Entry{Bucket: &buckets[i]}
+	}
+	return s.add(entries, cause)
+}
+
+// AddEvents records that events failed to write with cause
+func (s *Store) AddEvents(events []data.Event, cause error) error {
+	entries := make([]Entry, len(events))
+	for i := range events {
+		entries[i] = Entry{Event: &events[i]}
+	}
+	return s.add(entries, cause)
+}
+
+// add appends entries to the store, so a long outage costs one append per failed batch rather
+// than rewriting the file. A row failing again gets another line that load merges with the earlier ones.
+func (s *Store) add(entries []Entry, cause error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := os.MkdirAll(filepath.Dir(s.path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	var lines bytes.Buffer
+	encoder := json.NewEncoder(&lines)
+	now := time.Now().UTC()
+	for _, entry := range entries {
+		entry.Error = cause.Error()
+		entry.Attempts = 1
+		entry.FirstFailed = now
+		entry.LastFailed = now
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	// one write for the batch, so concurrent appends don't interleave
+	_, err = file.Write(lines.Bytes())
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Load returns every entry in the store
+func (s *Store) Load() ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+// Replace overwrites the entries returned by an earlier Load with entries, removing the file when
+// there are none left. This also compacts the lines of rows that failed several times into one.
+// Rows failing again since that Load, a sync can append while a replay runs, are not lost: the
+// file is read again right before it is replaced and their latest copy is kept, with the attempts
+// of both counted.
+func (s *Store) Replace(loaded, entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	attempts := make(map[key]int, len(loaded))
+	for _, entry := range loaded {
+		attempts[entry.key()] = entry.Attempts
+	}
+	merged := append([]Entry(nil), entries...)
+	index := make(map[key]int, len(merged))
+	for i, entry := range merged {
+		index[entry.key()] = i
+	}
+
+	for _, entry := range current {
+		before, ok := attempts[entry.key()]
+		if ok && entry.Attempts == before {
+			continue // unchanged since the load, entries has the say on it
+		}
+		i, ok := index[entry.key()]
+		if !ok {
+			index[entry.key()] = len(merged)
+			merged = append(merged, entry)
+			continue
+		}
+		// the caller failed it again too, count its attempts on top of the new copy
+		entry.Attempts += merged[i].Attempts - before
+		if merged[i].LastFailed.After(entry.LastFailed) {
+			entry.Error, entry.LastFailed = merged[i].Error, merged[i].LastFailed
+		}
+		merged[i] = entry
+	}
+
+	return s.write(merged)
+}
+
+func (s *Store) load() ([]Entry, error) {
+	file, err := os.Open(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+	index := make(map[key]int)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", s.path, line, err)
+		}
+		if (entry.Bucket == nil) == (entry.Event == nil) {
+			return nil, fmt.Errorf("%s line %d: entry must have either a bucket or an event", s.path, line)
+		}
+
+		if i, ok := index[entry.key()]; ok {
+			// keep the latest copy, heartbeats may have extended the event since
+			entry.Attempts += entries[i].Attempts
+			entry.FirstFailed = entries[i].FirstFailed
+			entries[i] = entry
+			continue
+		}
+		index[entry.key()] = len(entries)
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// write replaces the file through a rename, so a crash never leaves it half written
+func (s *Store) write(entries []Entry) error {
+	if len(entries) == 0 {
+		err := os.Remove(s.path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	err := os.MkdirAll(filepath.Dir(s.path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
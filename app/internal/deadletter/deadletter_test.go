@@ -0,0 +1,153 @@
+package deadletter
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/azaurus1/lifevisor/internal/data"
+)
+
+func TestAddMergesRepeatedFailures(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "lifevisor", "dead-letter.jsonl"))
+	event := data.Event{DeviceID: "laptop", ID: 7, BucketID: 1, Timestamp: time.Date(2024, 12, 13, 10, 0, 0, 0, time.UTC), Duration: 5}
+	other := data.Event{DeviceID: "laptop", ID: 8, BucketID: 1, Timestamp: time.Date(2024, 12, 13, 10, 0, 5, 0, time.UTC), Duration: 1}
+
+	if err := store.AddEvents([]data.Event{event}, errors.New("first")); err != nil {
+		t.Fatal(err)
+	}
+	first, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// heartbeats extended the event before it failed again
+	event.Duration = 30
+	if err := store.AddEvents([]data.Event{event, other}, errors.New("second")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddBuckets([]data.Bucket{{DeviceID: "laptop", Key: 1}}, errors.New("bucket")); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+
+	merged := entries[0]
+	if merged.Event == nil || merged.Event.ID != 7 {
+		t.Fatalf("first entry = %+v, want event 7", merged)
+	}
+	if merged.Attempts != 2 || merged.Error != "second" || merged.Event.Duration != 30 {
+		t.Errorf("event 7 = %+v with %d attempts, want the second failure of the latest copy", merged, merged.Attempts)
+	}
+	if !merged.FirstFailed.Equal(first[0].FirstFailed) {
+		t.Errorf("event 7 first failed at %v, want %v", merged.FirstFailed, first[0].FirstFailed)
+	}
+	if entries[1].Event == nil || entries[1].Event.ID != 8 || entries[1].Attempts != 1 {
+		t.Errorf("second entry = %+v, want event 8 failed once", entries[1])
+	}
+	if entries[2].Bucket == nil || entries[2].Bucket.Key != 1 {
+		t.Errorf("third entry = %+v, want bucket 1", entries[2])
+	}
+}
+
+func TestReplaceCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+	store := NewStore(path)
+	event := data.Event{DeviceID: "laptop", ID: 7, BucketID: 1}
+
+	for i := 0; i < 3; i++ {
+		if err := store.AddEvents([]data.Event{event}, errors.New("down")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Replace(entries, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(content), "\n"); lines != 1 {
+		t.Errorf("store has %d lines after Replace, want 1", lines)
+	}
+
+	entries, err = store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Attempts != 3 {
+		t.Errorf("got %+v, want one entry with 3 attempts", entries)
+	}
+
+	if err := store.Replace(entries, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("store file still exists after replacing it with nothing: %v", err)
+	}
+}
+
+func TestReplaceKeepsEntriesAddedSinceLoad(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "dead-letter.jsonl"))
+	replayed := data.Event{DeviceID: "laptop", ID: 7, BucketID: 1}
+	failing := data.Event{DeviceID: "laptop", ID: 8, BucketID: 1}
+	if err := store.AddEvents([]data.Event{replayed, failing}, errors.New("down")); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a sync fails both again and a new event while the replay runs
+	replayed.Duration = 30
+	fresh := data.Event{DeviceID: "laptop", ID: 9, BucketID: 1}
+	if err := store.AddEvents([]data.Event{replayed, failing, fresh}, errors.New("sync")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the replay wrote event 7 and failed event 8 once more
+	keep := loaded[1]
+	keep.Attempts++
+	keep.Error = "replay"
+	keep.LastFailed = time.Now().UTC().Add(time.Minute)
+	if err := store.Replace(loaded, []Entry{keep}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[int]Entry)
+	for _, entry := range entries {
+		got[entry.Event.ID] = entry
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3: %+v", len(entries), entries)
+	}
+	if e := got[7]; e.Attempts != 2 || e.Event.Duration != 30 {
+		t.Errorf("event 7 = %+v with %d attempts, want the copy the sync added with 2", e.Event, e.Attempts)
+	}
+	if e := got[8]; e.Attempts != 3 || e.Error != "replay" {
+		t.Errorf("event 8 has %d attempts and error %q, want 3 and the replay error", e.Attempts, e.Error)
+	}
+	if e := got[9]; e.Attempts != 1 {
+		t.Errorf("event 9 has %d attempts, want 1", e.Attempts)
+	}
+}
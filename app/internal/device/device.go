@@ -0,0 +1,53 @@
+package device
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ID returns the identity of this machine, generating and storing it on first use.
+// Every bucket and event synced from this machine is keyed by it in the destination.
+func ID() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(configDir, "lifevisor", "device-id")
+
+	content, err := os.ReadFile(path)
+	if err == nil {
+		if id := strings.TrimSpace(string(content)); id != "" {
+			return id, nil
+		}
+		// an emptied file would put every machine with one under the same blank id
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	// first run on this machine
+	id := uuid.NewString()
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return "", err
+	}
+	err = os.WriteFile(path, []byte(id+"\n"), 0o644)
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// Resolve returns override when set, otherwise the stored identity of this machine
+func Resolve(override string) (string, error) {
+	if override != "" {
+		return override, nil
+	}
+	return ID()
+}
@@ -0,0 +1,48 @@
+package device
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIDRegeneratesBlankFile(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configDir)
+	t.Setenv("HOME", configDir)
+	path := filepath.Join(configDir, "lifevisor", "device-id")
+	if dir, _ := os.UserConfigDir(); dir != configDir {
+		t.Skipf("user config dir is %s on this platform", dir)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(" \n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := ID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == "" {
+		t.Fatal("ID returned a blank id")
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(content)) != id {
+		t.Errorf("device-id file holds %q, want the new id %q", content, id)
+	}
+
+	again, err := ID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != id {
+		t.Errorf("second ID = %q, want the stored %q", again, id)
+	}
+}
@@ -2,257 +2,188 @@ package direct
 
 import (
 	"context"
+	"fmt"
 	"log"
-	"sync"
 	"time"
 
 	"github.com/azaurus1/lifevisor/internal/data"
+	"github.com/azaurus1/lifevisor/internal/deadletter"
+	"github.com/azaurus1/lifevisor/internal/pipeline"
+	"github.com/azaurus1/lifevisor/internal/source"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"zombiezen.com/go/sqlite"
-	"zombiezen.com/go/sqlite/sqlitex"
 )
 
-// This is for using a DSN and directly uploading to the DB
-func DirectInitialisation(ctx context.Context, dbType, sqlitePath, connString string, concurreny int) error {
-	var db data.Repository
-	var events []data.Event
-	var buckets []data.Bucket
-
-	// 1. get the sqlite db
-	sqliteConn, err := sqlite.OpenConn(sqlitePath, sqlite.OpenReadOnly)
+// This is for using a DSN and directly uploading to the DB, resume continues from the checkpoints of an earlier run
+func DirectInitialisation(ctx context.Context, dbType, sourcePath, connString, deviceID string, resume bool, opts pipeline.Options) error {
+	// 1. get the source
+	src, err := source.Open(sourcePath, deviceID)
 	if err != nil {
-		return err
-	}
-	// 2. get the db conn
-	if dbType == "pg" {
-		pgConn, err := pgxpool.New(ctx, connString)
-		if err != nil {
-			return err
-		}
-		db = data.NewPostgresRepository(pgConn)
+		return &pipeline.SourceError{Err: err}
 	}
+	defer src.Close()
 
-	// 3. run migrations
-	err = db.RunMigrations()
+	// 2. get the db conn and run migrations
+	pgConn, db, err := connect(ctx, dbType, connString)
 	if err != nil {
-		return err
+		return &pipeline.DestinationError{Err: err}
 	}
+	defer pgConn.Close()
 
-	// 4. read everything in the sqlite DB
-
-	// buckets first
-
-	err = sqlitex.ExecuteTransient(sqliteConn, "SELECT * from bucketmodel;", &sqlitex.ExecOptions{
-		ResultFunc: func(stmt *sqlite.Stmt) error {
-			// create new bucket
-			var bucket data.Bucket
-
-			layout := "2006-01-02T15:04:05.999999"
-			parsedTime, err := time.Parse(layout, stmt.ColumnText(2))
-			if err != nil {
-				log.Println(stmt.ColumnText(2))
-				log.Println("error in bucket time")
-				return err
-			}
+	// 3. pick up the checkpoints
+	var states map[int]data.SyncState
+	if resume {
+		states, err = db.GetSyncStates(ctx, deviceID)
+		if err != nil {
+			return &pipeline.DestinationError{Err: err}
+		}
+	}
 
-			bucket.Key = stmt.ColumnInt(0)
-			bucket.ID = stmt.ColumnText(1)
-			bucket.Created = parsedTime
-			bucket.Name = stmt.ColumnText(3)
-			bucket.Type = stmt.ColumnText(4)
-			bucket.Client = stmt.ColumnText(5)
-			bucket.Hostname = stmt.ColumnText(6)
+	// 4. stream everything in the source to the db
+	stats, err := pipeline.Run(ctx, src, db, states, opts)
+	log.Printf("Loaded to the remote database: %s", stats.Summary())
+	return err
+}
 
-			// add to events
-			buckets = append(buckets, bucket)
+// Syncer keeps the source and the database pool open across syncs
+type Syncer struct {
+	deviceID string
+	src      source.Source
+	pgConn   *pgxpool.Pool
+	db       data.Repository
+	opts     pipeline.Options
+}
 
-			return nil
-		},
-	})
+func NewSyncer(ctx context.Context, dbType, sourcePath, connString, deviceID string, opts pipeline.Options) (*Syncer, error) {
+	// Open the source
+	src, err := source.Open(sourcePath, deviceID)
 	if err != nil {
-		return err
+		return nil, &pipeline.SourceError{Err: err}
 	}
 
-	// then events
-
-	err = sqlitex.ExecuteTransient(sqliteConn, "SELECT * from eventmodel;", &sqlitex.ExecOptions{
-		ResultFunc: func(stmt *sqlite.Stmt) error {
-			// create new event
-			var event data.Event
-
-			layout := "2006-01-02 15:04:05.999999-07:00"
-			parsedTime, err := time.Parse(layout, stmt.ColumnText(2))
-			if err != nil {
-				log.Println("error in event time")
-				return err
-			}
-
-			event.ID = stmt.ColumnInt(0)
-			event.BucketID = stmt.ColumnInt(1)
-			event.Timestamp = parsedTime
-			event.Duration = stmt.ColumnFloat(3)
-			event.DataStr = stmt.ColumnText(4)
-
-			// add to events
-			events = append(events, event)
-
-			return nil
-		},
-	})
+	// Connect to the remote database
+	pgConn, db, err := connect(ctx, dbType, connString)
 	if err != nil {
-		return err
+		src.Close()
+		return nil, &pipeline.DestinationError{Err: err}
 	}
 
-	// 5. push to db
-	var bucketCount int
+	return &Syncer{
+		deviceID: deviceID,
+		src:      src,
+		pgConn:   pgConn,
+		db:       db,
+		opts:     opts,
+	}, nil
+}
 
-	for _, bucket := range buckets {
-		db.InsertBucket(bucket)
-		bucketCount++
-	}
+func (s *Syncer) Close() error {
+	s.pgConn.Close()
+	return s.src.Close()
+}
 
-	log.Printf("wrote %v buckets to db", bucketCount)
+// Sync pushes everything past the watermarks of this device
+func (s *Syncer) Sync(ctx context.Context) error {
+	stats, err := pipeline.Sync(ctx, s.src, s.db, s.deviceID, s.opts)
+	log.Printf("Synced to the remote database: %s", stats.Summary())
+	return err
+}
 
-	var wg sync.WaitGroup
-	eventCh := make(chan data.Event, concurreny)
+// GoalUsage reads the usage of a goal from the database the syncer writes to
+func (s *Syncer) GoalUsage(ctx context.Context, kind, target string, start, end time.Time) (float64, error) {
+	return s.db.GoalUsage(ctx, kind, target, start, end)
+}
 
-	// Worker function
-	worker := func(eventCh <-chan data.Event, wg *sync.WaitGroup) {
-		defer wg.Done()
-		for event := range eventCh {
-			db.InsertEvent(event) // Process event
-		}
+// RetryFailed replays the buckets and events of deviceID that are in the dead-letter store
+func RetryFailed(ctx context.Context, dbType, connString, deviceID string, store *deadletter.Store) error {
+	pgConn, db, err := connect(ctx, dbType, connString)
+	if err != nil {
+		return &pipeline.DestinationError{Err: err}
 	}
+	defer pgConn.Close()
 
-	// Start workers
-	for i := 0; i < concurreny; i++ {
-		wg.Add(1)
-		go worker(eventCh, &wg)
-	}
+	stats, err := pipeline.Replay(ctx, store, db, deviceID)
+	log.Printf("Retried from %s: %s", store.Path(), stats.Summary())
+	return err
+}
 
-	// Send events to workers
-	for _, event := range events {
-		eventCh <- event
+// Recategorize stores rules in the database unless they are nil and categorises every event again
+func Recategorize(ctx context.Context, dbType, connString string, rules []data.CategoryRule) error {
+	pgConn, db, err := connect(ctx, dbType, connString)
+	if err != nil {
+		return &pipeline.DestinationError{Err: err}
 	}
+	defer pgConn.Close()
 
-	log.Printf("Successfully loaded %d buckets and %d events to the remote database", len(buckets), len(events))
+	n, err := pipeline.Recategorize(ctx, db, rules)
+	if err != nil {
+		return err
+	}
+	log.Printf("Recategorised the remote database: %d events changed category", n)
 	return nil
 }
 
-func DirectSync(ctx context.Context, dbType, sourcePath, connString string, interval int) error {
-	var db data.Repository
-	var events []data.Event
-	var buckets []data.Bucket
-
-	// Normalize to UTC for consistency
-	currentTime := time.Now().UTC()
-	cutoffTime := currentTime.Add(-time.Duration(interval) * time.Second)
-
-	// Open the SQLite connection
-	sqliteConn, err := sqlite.OpenConn(sourcePath, sqlite.OpenReadOnly)
+// Report reads the report from start to end from the database
+func Report(ctx context.Context, dbType, connString string, start, end time.Time, top int) (data.Report, error) {
+	pgConn, db, err := connect(ctx, dbType, connString)
 	if err != nil {
-		return err
+		return data.Report{}, &pipeline.DestinationError{Err: err}
 	}
-	defer sqliteConn.Close()
+	defer pgConn.Close()
 
-	// Connect to the remote database
-	if dbType == "pg" {
-		pgConn, err := pgxpool.New(ctx, connString)
-		if err != nil {
-			return err
-		}
-		defer pgConn.Close()
-		db = data.NewPostgresRepository(pgConn)
+	report, err := db.Report(ctx, start, end, top)
+	if err != nil {
+		return report, &pipeline.DestinationError{Err: err}
 	}
+	return report, nil
+}
 
-	// Read buckets from SQLite database
-	err = sqlitex.ExecuteTransient(sqliteConn, "SELECT * FROM bucketmodel WHERE created >= ?;", &sqlitex.ExecOptions{
-		Args: []interface{}{cutoffTime.Format("2006-01-02T15:04:05.999999")},
-		ResultFunc: func(stmt *sqlite.Stmt) error {
-			var bucket data.Bucket
-
-			parsedTime, err := time.Parse("2006-01-02T15:04:05.999999", stmt.ColumnText(2))
-			if err != nil {
-				log.Println("Error parsing bucket time:", stmt.ColumnText(2))
-				return err
-			}
-
-			bucket.Key = stmt.ColumnInt(0)
-			bucket.ID = stmt.ColumnText(1)
-			bucket.Created = parsedTime
-			bucket.Name = stmt.ColumnText(3)
-			bucket.Type = stmt.ColumnText(4)
-			bucket.Client = stmt.ColumnText(5)
-			bucket.Hostname = stmt.ColumnText(6)
-
-			buckets = append(buckets, bucket)
-			return nil
-		},
-	})
+// ListSessions reads the sessions overlapping start to end from the database
+func ListSessions(ctx context.Context, dbType, connString string, start, end time.Time) ([]data.Session, error) {
+	pgConn, db, err := connect(ctx, dbType, connString)
 	if err != nil {
-		return err
+		return nil, &pipeline.DestinationError{Err: err}
 	}
+	defer pgConn.Close()
 
-	// Read events from SQLite database
-	err = sqlitex.ExecuteTransient(sqliteConn, "SELECT * FROM eventmodel WHERE timestamp >= ?;", &sqlitex.ExecOptions{
-		Args: []interface{}{cutoffTime.Format("2006-01-02 15:04:05.999999-07:00")},
-		ResultFunc: func(stmt *sqlite.Stmt) error {
-			var event data.Event
-
-			parsedTime, err := time.Parse("2006-01-02 15:04:05.999999-07:00", stmt.ColumnText(2))
-			if err != nil {
-				log.Println("Error parsing event time")
-				return err
-			}
-
-			event.ID = stmt.ColumnInt(0)
-			event.BucketID = stmt.ColumnInt(1)
-			event.Timestamp = parsedTime
-			event.Duration = stmt.ColumnFloat(3)
-			event.DataStr = stmt.ColumnText(4)
-
-			events = append(events, event)
-			return nil
-		},
-	})
+	sessions, err := db.ListSessions(ctx, start, end)
 	if err != nil {
-		return err
+		return nil, &pipeline.DestinationError{Err: err}
 	}
+	return sessions, nil
+}
 
-	// Push buckets to the remote database
-	for _, bucket := range buckets {
-		err := db.InsertBucket(bucket)
-		if err != nil {
-			log.Printf("Error inserting bucket: %v", err)
-		}
+// Switches reads the app switches from start to end from the database
+func Switches(ctx context.Context, dbType, connString string, start, end time.Time, top int) (data.SwitchReport, error) {
+	pgConn, db, err := connect(ctx, dbType, connString)
+	if err != nil {
+		return data.SwitchReport{}, &pipeline.DestinationError{Err: err}
 	}
+	defer pgConn.Close()
 
-	// Push events to the remote database concurrently
-	const workerCount = 1000
-	var wg sync.WaitGroup
-	eventCh := make(chan data.Event, workerCount)
-
-	worker := func(eventCh <-chan data.Event, wg *sync.WaitGroup) {
-		defer wg.Done()
-		for event := range eventCh {
-			if err := db.InsertEvent(event); err != nil {
-				log.Printf("Error inserting event: %v", err)
-			}
-		}
+	report, err := db.Switches(ctx, start, end, top)
+	if err != nil {
+		return report, &pipeline.DestinationError{Err: err}
 	}
+	return report, nil
+}
 
-	for i := 0; i < workerCount; i++ {
-		wg.Add(1)
-		go worker(eventCh, &wg)
+// connect opens the database pool and brings the schema up to date
+func connect(ctx context.Context, dbType, connString string) (*pgxpool.Pool, data.Repository, error) {
+	if dbType != "pg" {
+		return nil, nil, fmt.Errorf("unsupported database type: %s", dbType)
 	}
 
-	for _, event := range events {
-		eventCh <- event
+	pgConn, err := data.ConnectAsTenant(ctx, connString, data.DefaultTenant)
+	if err != nil {
+		return nil, nil, err
 	}
-	close(eventCh)
+	db := data.NewPostgresRepository(pgConn)
 
-	wg.Wait()
+	err = db.RunMigrations()
+	if err != nil {
+		pgConn.Close()
+		return nil, nil, err
+	}
 
-	log.Printf("Successfully synced %d buckets and %d events to the remote database", len(buckets), len(events))
-	return nil
+	return pgConn, db, nil
 }
@@ -0,0 +1,135 @@
+package goals
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Goal is a daily limit (Max) or target (Min) on the active time spent on an app, a site or a category
+type Goal struct {
+	Name   string
+	Kind   string // app, site or category
+	Target string
+	Max    time.Duration
+	Min    time.Duration
+}
+
+// Validate checks that the goal names a known kind and exactly one of Max and Min
+func (g Goal) Validate() error {
+	if g.Kind != "app" && g.Kind != "site" && g.Kind != "category" {
+		return fmt.Errorf("goal %q needs one of app, site or category", g.Name)
+	}
+	if g.Target == "" {
+		return fmt.Errorf("goal %q has an empty %s", g.Name, g.Kind)
+	}
+	if (g.Max > 0) == (g.Min > 0) {
+		return fmt.Errorf("goal %q needs either a positive max or a positive min", g.Name)
+	}
+	return nil
+}
+
+// Alert is a goal that was reached or a limit that was exceeded on Day
+type Alert struct {
+	Goal    Goal
+	Day     string // YYYY-MM-DD
+	Used    time.Duration
+	Message string
+}
+
+// UsageReader is a destination that can tell the active seconds spent on an app, site or category
+type UsageReader interface {
+	GoalUsage(ctx context.Context, kind, target string, start, end time.Time) (float64, error)
+}
+
+// Checker evaluates goals against the time used today and notifies about each goal once a day
+type Checker struct {
+	Goals     []Goal
+	Notifiers []Notifier
+	State     *State
+	Location  *time.Location   // days start at midnight here
+	Now       func() time.Time // time.Now when nil
+}
+
+// Check reads the usage of every goal since midnight and sends the alerts not sent today yet
+func (c *Checker) Check(ctx context.Context, reader UsageReader) error {
+	clock := c.Now
+	if clock == nil {
+		clock = time.Now
+	}
+	now := clock().In(c.Location)
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, c.Location)
+	day := start.Format(time.DateOnly)
+
+	sent, err := c.State.Load()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, goal := range c.Goals {
+		if sent[goal.Name] == day {
+			continue
+		}
+
+		seconds, err := reader.GoalUsage(ctx, goal.Kind, goal.Target, start, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("goal %q: %w", goal.Name, err))
+			continue
+		}
+
+		alert, ok := evaluate(goal, day, time.Duration(seconds*float64(time.Second)))
+		if !ok {
+			continue
+		}
+
+		// a goal counts as notified once any notifier got it through
+		var delivered bool
+		for _, notifier := range c.Notifiers {
+			err := notifier.Notify(ctx, alert)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("notifying about goal %q: %w", goal.Name, err))
+				continue
+			}
+			delivered = true
+		}
+		if delivered {
+			log.Printf("Notified: %s", alert.Message)
+			sent[goal.Name] = day
+		}
+	}
+
+	err = c.State.Save(sent)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// evaluate returns the alert for goal after used time today, if there is one
+func evaluate(goal Goal, day string, used time.Duration) (Alert, bool) {
+	alert := Alert{Goal: goal, Day: day, Used: used}
+
+	switch {
+	case goal.Max > 0 && used > goal.Max:
+		alert.Message = fmt.Sprintf("Limit exceeded: %s on %s today, the limit is %s", formatDuration(used), goal.Target, formatDuration(goal.Max))
+	case goal.Min > 0 && used >= goal.Min:
+		alert.Message = fmt.Sprintf("Goal reached: %s on %s today, the goal was %s", formatDuration(used), goal.Target, formatDuration(goal.Min))
+	default:
+		return alert, false
+	}
+
+	return alert, true
+}
+
+// formatDuration prints a duration in hours and minutes
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	if d >= time.Hour {
+		return fmt.Sprintf("%dh %02dm", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return fmt.Sprintf("%dm", int(d.Minutes()))
+}
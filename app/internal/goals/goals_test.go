@@ -0,0 +1,201 @@
+package goals
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEvaluate(t *testing.T) {
+	limit := Goal{Name: "reddit", Kind: "site", Target: "reddit.com", Max: 30 * time.Minute}
+	target := Goal{Name: "deep work", Kind: "category", Target: "Work", Min: 4 * time.Hour}
+
+	tests := []struct {
+		name    string
+		goal    Goal
+		used    time.Duration
+		alert   bool
+		message string
+	}{
+		{"under the limit", limit, 10 * time.Minute, false, ""},
+		{"at the limit", limit, 30 * time.Minute, false, ""},
+		{"over the limit", limit, 45 * time.Minute, true, "Limit exceeded: 45m on reddit.com today, the limit is 30m"},
+		{"short of the goal", target, 3*time.Hour + 59*time.Minute, false, ""},
+		{"goal reached", target, 4 * time.Hour, true, "Goal reached: 4h 00m on Work today, the goal was 4h 00m"},
+		{"goal passed", target, 5*time.Hour + 30*time.Minute, true, "Goal reached: 5h 30m on Work today, the goal was 4h 00m"},
+	}
+
+	for _, test := range tests {
+		alert, ok := evaluate(test.goal, "2024-12-13", test.used)
+		if ok != test.alert {
+			t.Errorf("%s: alert = %v, want %v", test.name, ok, test.alert)
+			continue
+		}
+		if ok && alert.Message != test.message {
+			t.Errorf("%s: message = %q, want %q", test.name, alert.Message, test.message)
+		}
+		if ok && (alert.Day != "2024-12-13" || alert.Used != test.used) {
+			t.Errorf("%s: alert = %+v", test.name, alert)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		goal  Goal
+		valid bool
+	}{
+		{Goal{Name: "a", Kind: "app", Target: "Slack", Max: time.Hour}, true},
+		{Goal{Name: "b", Kind: "category", Target: "Work", Min: time.Hour}, true},
+		{Goal{Name: "c", Kind: "window", Target: "Slack", Max: time.Hour}, false},
+		{Goal{Name: "d", Kind: "app", Max: time.Hour}, false},
+		{Goal{Name: "e", Kind: "app", Target: "Slack"}, false},
+		{Goal{Name: "f", Kind: "app", Target: "Slack", Max: time.Hour, Min: time.Minute}, false},
+	}
+	for _, test := range tests {
+		if err := test.goal.Validate(); (err == nil) != test.valid {
+			t.Errorf("Validate(%+v) = %v, want valid %v", test.goal, err, test.valid)
+		}
+	}
+}
+
+// usage answers every goal with the seconds set for its target
+type usage struct {
+	seconds map[string]float64
+	reads   int
+}
+
+func (u *usage) GoalUsage(ctx context.Context, kind, target string, start, end time.Time) (float64, error) {
+	u.reads++
+	return u.seconds[target], nil
+}
+
+// recorder keeps the alerts it is sent, failing them while err is set
+type recorder struct {
+	alerts []Alert
+	err    error
+}
+
+func (r *recorder) Notify(ctx context.Context, alert Alert) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.alerts = append(r.alerts, alert)
+	return nil
+}
+
+func TestCheckNotifiesOncePerDay(t *testing.T) {
+	location := time.FixedZone("UTC+2", 2*60*60)
+	now := time.Date(2024, 12, 13, 15, 0, 0, 0, location)
+
+	reader := &usage{seconds: map[string]float64{"reddit.com": 2700, "Work": 600}}
+	notifier := &recorder{}
+	checker := &Checker{
+		Goals: []Goal{
+			{Name: "reddit", Kind: "site", Target: "reddit.com", Max: 30 * time.Minute},
+			{Name: "deep work", Kind: "category", Target: "Work", Min: time.Hour},
+		},
+		Notifiers: []Notifier{notifier},
+		State:     NewState(filepath.Join(t.TempDir(), "goals.json")),
+		Location:  location,
+		Now:       func() time.Time { return now },
+	}
+
+	steps := []struct {
+		name   string
+		at     time.Time
+		work   float64
+		alerts []string // goals alerted about in this step
+	}{
+		{"limit exceeded", now, 600, []string{"reddit"}},
+		{"same day again", now.Add(time.Hour), 600, nil},
+		{"goal reached later that day", now.Add(2 * time.Hour), 3600, []string{"deep work"}},
+		{"still the same day", time.Date(2024, 12, 13, 23, 59, 0, 0, location), 3600, nil},
+		{"next day", time.Date(2024, 12, 14, 0, 1, 0, 0, location), 3600, []string{"reddit", "deep work"}},
+	}
+
+	for _, step := range steps {
+		now = step.at
+		reader.seconds["Work"] = step.work
+		notifier.alerts = nil
+
+		if err := checker.Check(context.Background(), reader); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		var got []string
+		for _, alert := range notifier.alerts {
+			got = append(got, alert.Goal.Name)
+			if alert.Day != step.at.Format(time.DateOnly) {
+				t.Errorf("%s: alert for day %s, want %s", step.name, alert.Day, step.at.Format(time.DateOnly))
+			}
+		}
+		if len(got) != len(step.alerts) {
+			t.Errorf("%s: alerted %v, want %v", step.name, got, step.alerts)
+			continue
+		}
+		for i := range got {
+			if got[i] != step.alerts[i] {
+				t.Errorf("%s: alerted %v, want %v", step.name, got, step.alerts)
+				break
+			}
+		}
+	}
+}
+
+func TestCheckRetriesUndelivered(t *testing.T) {
+	now := time.Date(2024, 12, 13, 15, 0, 0, 0, time.UTC)
+	reader := &usage{seconds: map[string]float64{"reddit.com": 2700}}
+	notifier := &recorder{err: errors.New("no display")}
+	checker := &Checker{
+		Goals:     []Goal{{Name: "reddit", Kind: "site", Target: "reddit.com", Max: 30 * time.Minute}},
+		Notifiers: []Notifier{notifier},
+		State:     NewState(filepath.Join(t.TempDir(), "goals.json")),
+		Location:  time.UTC,
+		Now:       func() time.Time { return now },
+	}
+
+	if err := checker.Check(context.Background(), reader); err == nil {
+		t.Error("Check hid the failed notification")
+	}
+
+	// nothing got through, so the alert is sent on the next check
+	notifier.err = nil
+	if err := checker.Check(context.Background(), reader); err != nil {
+		t.Fatal(err)
+	}
+	if len(notifier.alerts) != 1 {
+		t.Errorf("got %d alerts after the notifier recovered, want 1", len(notifier.alerts))
+	}
+
+	// and not again that day, without even reading the usage
+	reads := reader.reads
+	if err := checker.Check(context.Background(), reader); err != nil {
+		t.Fatal(err)
+	}
+	if len(notifier.alerts) != 1 || reader.reads != reads {
+		t.Errorf("checked a goal already notified about today: %d alerts, %d reads", len(notifier.alerts), reader.reads-reads)
+	}
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	state := NewState(filepath.Join(t.TempDir(), "lifevisor", "goals.json"))
+
+	sent, err := state.Load()
+	if err != nil || len(sent) != 0 {
+		t.Fatalf("Load of a missing file = %v, %v, want an empty map", sent, err)
+	}
+
+	if err := state.Save(map[string]string{"reddit": "2024-12-13"}); err != nil {
+		t.Fatal(err)
+	}
+	sent, err = state.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent["reddit"] != "2024-12-13" || len(sent) != 1 {
+		t.Errorf("Load = %v after Save", sent)
+	}
+}
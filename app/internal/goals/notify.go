@@ -0,0 +1,101 @@
+package goals
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"time"
+)
+
+// Notifier delivers alerts to the user
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// Stdout prints alerts to W
+type Stdout struct {
+	W io.Writer
+}
+
+func (n *Stdout) Notify(ctx context.Context, alert Alert) error {
+	_, err := fmt.Fprintln(n.W, alert.Message)
+	return err
+}
+
+// Command runs a program with the title "lifevisor" and the message appended to Args,
+// which suits notify-send
+type Command struct {
+	Args []string
+}
+
+func (n *Command) Notify(ctx context.Context, alert Alert) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	args := append(append([]string{}, n.Args[1:]...), "lifevisor", alert.Message)
+	output, err := exec.CommandContext(ctx, n.Args[0], args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", n.Args[0], err, bytes.TrimSpace(output))
+	}
+	return nil
+}
+
+// Webhook POSTs alerts as JSON to URL
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewWebhook(url string) *Webhook {
+	return &Webhook{URL: url, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// webhookPayload is the JSON body of a webhook, durations in seconds
+type webhookPayload struct {
+	Goal    string
+	Kind    string
+	Target  string
+	Day     string
+	Used    float64
+	Max     float64 `json:",omitempty"`
+	Min     float64 `json:",omitempty"`
+	Message string
+}
+
+func (n *Webhook) Notify(ctx context.Context, alert Alert) error {
+	payload, err := json.Marshal(webhookPayload{
+		Goal:    alert.Goal.Name,
+		Kind:    alert.Goal.Kind,
+		Target:  alert.Goal.Target,
+		Day:     alert.Day,
+		Used:    alert.Used.Seconds(),
+		Max:     alert.Goal.Max.Seconds(),
+		Min:     alert.Goal.Min.Seconds(),
+		Message: alert.Message,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook failed with status: %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package goals
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// State remembers the day each goal was last notified about, in a JSON file
+type State struct {
+	path string
+}
+
+// DefaultStatePath is goals.json in the lifevisor directory of $XDG_STATE_HOME, ~/.local/state by default
+func DefaultStatePath() (string, error) {
+	stateDir := os.Getenv("XDG_STATE_HOME")
+	if stateDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		stateDir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(stateDir, "lifevisor", "goals.json"), nil
+}
+
+func NewState(path string) *State {
+	return &State{path: path}
+}
+
+// Load returns the day every goal was last notified about, by goal name
+func (s *State) Load() (map[string]string, error) {
+	sent := make(map[string]string)
+
+	content, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return sent, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(content, &sent)
+	if err != nil {
+		return nil, err
+	}
+	return sent, nil
+}
+
+// Save replaces the file through a rename, so a crash never leaves it half written
+func (s *State) Save(sent map[string]string) error {
+	content, err := json.MarshalIndent(sent, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(s.path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	err = os.WriteFile(tmp, content, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,239 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Client sends requests to lifevisor-service, retrying the ones that may succeed later
+// and pausing everyone once the service looks down
+type Client struct {
+	HTTP        *http.Client
+	Timeout     time.Duration // per attempt
+	MaxAttempts int
+	BaseBackoff time.Duration // delay before the second attempt, doubled on every further one
+	MaxBackoff  time.Duration // also caps Retry-After
+	Token       string        // bearer token sent with every request, if set
+
+	breaker *breaker
+}
+
+func NewClient() *Client {
+	return &Client{
+		// one client per destination so connections are reused between batches
+		HTTP: &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				MaxIdleConnsPerHost: 4,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+		Timeout:     60 * time.Second,
+		MaxAttempts: 5,
+		BaseBackoff: 500 * time.Millisecond,
+		MaxBackoff:  30 * time.Second,
+		breaker: &breaker{
+			threshold:   5,
+			cooldown:    30 * time.Second,
+			maxCooldown: 5 * time.Minute,
+		},
+	}
+}
+
+// StatusError is a response from the HTTP service other than 2xx
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("HTTP request failed with status: %v", e.Status)
+}
+
+// Retryable reports whether the service may accept the same request later
+func (e *StatusError) Retryable() bool {
+	return e.Code == http.StatusTooManyRequests || e.Code >= 500
+}
+
+// Do sends body to url, retrying network errors, 5xx and 429 with exponential backoff.
+// While the circuit breaker is open the request waits for it to close and is not retried.
+// Waiting stops when ctx is done. The response is only returned for a 2xx status, the caller closes its body.
+func (c *Client) Do(ctx context.Context, method, url, contentType string, body []byte) (*http.Response, error) {
+	err := c.breaker.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		var resp *http.Response
+		var retryAfter time.Duration
+		resp, retryAfter, err = c.attempt(ctx, method, url, contentType, body)
+		if err == nil {
+			c.breaker.success()
+			return resp, nil
+		}
+
+		var status *StatusError
+		if errors.As(err, &status) && !status.Retryable() {
+			// the service is up, it just refused the request
+			c.breaker.success()
+			return nil, err
+		}
+		if c.breaker.failure() || attempt >= c.MaxAttempts {
+			return nil, err
+		}
+
+		delay := c.backoff(attempt)
+		if retryAfter > 0 {
+			delay = min(retryAfter, c.MaxBackoff)
+		}
+		log.Printf("Request to %s failed (attempt %d of %d), retrying in %v: %v", url, attempt, c.MaxAttempts, delay, err)
+		if sleepErr := sleep(ctx, delay); sleepErr != nil {
+			return nil, sleepErr
+		}
+	}
+}
+
+// sleep waits for d, returning early with the error of ctx once it is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// attempt sends a single request, returning the delay asked for by a Retry-After header on failure
+func (c *Client) attempt(ctx context.Context, method, url, contentType string, body []byte) (*http.Response, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, 0, fmt.Errorf("error creating HTTP request: %v", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		cancel()
+		return nil, 0, fmt.Errorf("error making HTTP request: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		cancel()
+		return nil, parseRetryAfter(resp.Header.Get("Retry-After")), &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+
+	// the timeout covers reading the body too
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, 0, nil
+}
+
+// backoff is the delay after the given failed attempt, spread by up to half so clients don't retry in lockstep
+func (c *Client) backoff(attempt int) time.Duration {
+	d := min(c.BaseBackoff<<(attempt-1), c.MaxBackoff)
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// breaker opens after threshold consecutive failed attempts. While open, requests wait for the
+// cooldown to pass and then go through as probes; every failed probe doubles the cooldown.
+type breaker struct {
+	threshold   int
+	cooldown    time.Duration
+	maxCooldown time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	current   time.Duration // cooldown of the open circuit
+	openUntil time.Time
+}
+
+// wait blocks until the cooldown of an open circuit has passed or ctx is done
+func (b *breaker) wait(ctx context.Context) error {
+	b.mu.Lock()
+	pause := time.Until(b.openUntil)
+	b.mu.Unlock()
+
+	if pause > 0 {
+		return sleep(ctx, pause)
+	}
+	return ctx.Err()
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures >= b.threshold {
+		log.Println("lifevisor-service is reachable again, resuming")
+	}
+	b.failures = 0
+	b.current = 0
+	b.openUntil = time.Time{}
+}
+
+// failure counts a failed attempt and reports whether it opened the circuit
+func (b *breaker) failure() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.failures < b.threshold {
+		return false
+	}
+
+	if b.current == 0 {
+		b.current = b.cooldown
+	} else {
+		b.current = min(b.current*2, b.maxCooldown)
+	}
+	b.openUntil = time.Now().Add(b.current)
+	log.Printf("lifevisor-service looks down after %d failed requests, pausing for %v", b.failures, b.current)
+	return true
+}
@@ -0,0 +1,71 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDoStopsBackoffWhenContextIsDone(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewClient()
+	client.BaseBackoff = time.Minute
+	client.MaxBackoff = time.Minute
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.Do(ctx, http.MethodGet, server.URL, "", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Do returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Do took %v, it should stop waiting once the context is done", elapsed)
+	}
+}
+
+func TestDoStopsBreakerWaitWhenContextIsDone(t *testing.T) {
+	client := NewClient()
+	client.breaker.openUntil = time.Now().Add(time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := client.Do(ctx, http.MethodGet, "http://127.0.0.1:0", "", nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Do returned %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestDoRetriesUnavailable(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewClient()
+	client.BaseBackoff = time.Millisecond
+
+	resp, err := client.Do(context.Background(), http.MethodGet, server.URL, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if calls != 3 {
+		t.Errorf("server saw %d requests, want 3", calls)
+	}
+}
@@ -7,276 +7,424 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"sync"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/azaurus1/lifevisor/internal/data"
-	"zombiezen.com/go/sqlite"
-	"zombiezen.com/go/sqlite/sqlitex"
+	"github.com/azaurus1/lifevisor/internal/deadletter"
+	"github.com/azaurus1/lifevisor/internal/pipeline"
+	"github.com/azaurus1/lifevisor/internal/source"
 )
 
-func HttpInitialisation(ctx context.Context, sqlitePath, url string, concurrency int) error {
-	var events []data.Event
-	var buckets []data.Bucket
+// HttpInitialisation loads everything to lifevisor-service, resume continues from the checkpoints of an earlier run
+func HttpInitialisation(ctx context.Context, sourcePath, url, token, deviceID string, resume bool, opts pipeline.Options) error {
+	// 1. get the source
+	src, err := source.Open(sourcePath, deviceID)
+	if err != nil {
+		return &pipeline.SourceError{Err: err}
+	}
+	defer src.Close()
+
+	dest := NewDestination(url, token)
+
+	// 2. pick up the checkpoints
+	var states map[int]data.SyncState
+	if resume {
+		states, err = dest.GetSyncStates(ctx, deviceID)
+		if err != nil {
+			return &pipeline.DestinationError{Err: err}
+		}
+	}
+
+	// 3. stream everything in the source to the HTTP service
+	stats, err := pipeline.Run(ctx, src, dest, states, opts)
+	log.Printf("Loaded to the HTTP service: %s", stats.Summary())
+	return err
+}
 
-	// 1. get the sqlite db
-	sqliteConn, err := sqlite.OpenConn(sqlitePath, sqlite.OpenReadOnly)
+// Syncer keeps the source open across syncs
+type Syncer struct {
+	deviceID string
+	src      source.Source
+	dest     *Destination
+	opts     pipeline.Options
+}
+
+func NewSyncer(sourcePath, url, token, deviceID string, opts pipeline.Options) (*Syncer, error) {
+	// Open the source
+	src, err := source.Open(sourcePath, deviceID)
 	if err != nil {
-		return err
+		return nil, &pipeline.SourceError{Err: err}
 	}
 
-	// 2. read everything in the sqlite DB
-
-	// buckets first
-	err = sqlitex.ExecuteTransient(sqliteConn, "SELECT * from bucketmodel;", &sqlitex.ExecOptions{
-		ResultFunc: func(stmt *sqlite.Stmt) error {
-			// create new bucket
-			var bucket data.Bucket
-
-			layout := "2006-01-02T15:04:05.999999"
-			parsedTime, err := time.Parse(layout, stmt.ColumnText(2))
-			if err != nil {
-				log.Println(stmt.ColumnText(2))
-				log.Println("error in bucket time")
-				return err
-			}
-
-			bucket.Key = stmt.ColumnInt(0)
-			bucket.ID = stmt.ColumnText(1)
-			bucket.Created = parsedTime
-			bucket.Name = stmt.ColumnText(3)
-			bucket.Type = stmt.ColumnText(4)
-			bucket.Client = stmt.ColumnText(5)
-			bucket.Hostname = stmt.ColumnText(6)
-
-			// add to buckets
-			buckets = append(buckets, bucket)
-
-			return nil
-		},
-	})
+	return &Syncer{
+		deviceID: deviceID,
+		src:      src,
+		dest:     NewDestination(url, token),
+		opts:     opts,
+	}, nil
+}
+
+func (s *Syncer) Close() error {
+	return s.src.Close()
+}
+
+// Sync pushes everything past the watermarks of this device
+func (s *Syncer) Sync(ctx context.Context) error {
+	stats, err := pipeline.Sync(ctx, s.src, s.dest, s.deviceID, s.opts)
+	log.Printf("Synced to the HTTP service: %s", stats.Summary())
+	return err
+}
+
+// GoalUsage reads the usage of a goal from the service the syncer writes to
+func (s *Syncer) GoalUsage(ctx context.Context, kind, target string, start, end time.Time) (float64, error) {
+	return s.dest.GoalUsage(ctx, kind, target, start, end)
+}
+
+// RetryFailed replays the buckets and events of deviceID that are in the dead-letter store
+func RetryFailed(ctx context.Context, url, token, deviceID string, store *deadletter.Store) error {
+	stats, err := pipeline.Replay(ctx, store, NewDestination(url, token), deviceID)
+	log.Printf("Retried from %s: %s", store.Path(), stats.Summary())
+	return err
+}
+
+// Recategorize stores rules in the service unless they are nil and categorises every event again
+func Recategorize(ctx context.Context, url, token string, rules []data.CategoryRule) error {
+	n, err := pipeline.Recategorize(ctx, NewDestination(url, token), rules)
 	if err != nil {
 		return err
 	}
+	log.Printf("Recategorised the HTTP service: %d events changed category", n)
+	return nil
+}
+
+// Report reads the report from start to end from the service
+func Report(ctx context.Context, url, token string, start, end time.Time, top int) (data.Report, error) {
+	report, err := NewDestination(url, token).Report(ctx, start, end, top)
+	if err != nil {
+		return report, &pipeline.DestinationError{Err: err}
+	}
+	return report, nil
+}
+
+// ListSessions reads the sessions overlapping start to end from the service
+func ListSessions(ctx context.Context, url, token string, start, end time.Time) ([]data.Session, error) {
+	sessions, err := NewDestination(url, token).ListSessions(ctx, start, end)
+	if err != nil {
+		return nil, &pipeline.DestinationError{Err: err}
+	}
+	return sessions, nil
+}
+
+// Switches reads the app switches from start to end from the service
+func Switches(ctx context.Context, url, token string, start, end time.Time, top int) (data.SwitchReport, error) {
+	report, err := NewDestination(url, token).Switches(ctx, start, end, top)
+	if err != nil {
+		return report, &pipeline.DestinationError{Err: err}
+	}
+	return report, nil
+}
 
-	// then events
-	err = sqlitex.ExecuteTransient(sqliteConn, "SELECT * from eventmodel;", &sqlitex.ExecOptions{
-		ResultFunc: func(stmt *sqlite.Stmt) error {
-			// create new event
-			var event data.Event
+// Destination writes to lifevisor-service at url, authenticating with token
+type Destination struct {
+	url    string
+	client *Client
+}
 
-			layout := "2006-01-02 15:04:05.999999-07:00"
-			parsedTime, err := time.Parse(layout, stmt.ColumnText(2))
-			if err != nil {
-				log.Println("error in event time")
-				return err
-			}
+func NewDestination(url, token string) *Destination {
+	client := NewClient()
+	client.Token = token
+	return &Destination{url: url, client: client}
+}
+
+func (d *Destination) GetSyncStates(ctx context.Context, deviceID string) (map[int]data.SyncState, error) {
+	return fetchSyncStates(ctx, d.client, d.url+"/sync-state?device="+url.QueryEscape(deviceID))
+}
+
+func (d *Destination) InsertBuckets(ctx context.Context, buckets []data.Bucket) error {
+	return sendBatch(ctx, d.client, d.url+"/v1/buckets:batch", buckets, nil)
+}
+
+func (d *Destination) InsertEvents(ctx context.Context, events []data.Event) (data.WriteResult, error) {
+	var result data.WriteResult
+	err := sendBatch(ctx, d.client, d.url+"/v1/events:batch", events, &result)
+	return result, err
+}
 
-			event.ID = stmt.ColumnInt(0)
-			event.BucketID = stmt.ColumnInt(1)
-			event.Timestamp = parsedTime
-			event.Duration = stmt.ColumnFloat(3)
-			event.DataStr = stmt.ColumnText(4)
+func (d *Destination) UpdateSyncState(ctx context.Context, state data.SyncState) error {
+	return sendToHTTP(ctx, d.client, d.url+"/sync-state", state)
+}
 
-			// add to events
-			events = append(events, event)
+// RefreshActiveEvents asks the service to bring active time up to date with the events written
+func (d *Destination) RefreshActiveEvents(ctx context.Context) (int, error) {
+	return postForCount(ctx, d.client, d.url+"/v1/active:refresh")
+}
 
-			return nil
-		},
-	})
+// SetCategoryRules replaces the categorisation rules in the service, reporting whether they changed
+func (d *Destination) SetCategoryRules(ctx context.Context, rules []data.CategoryRule) (bool, error) {
+	if rules == nil {
+		rules = []data.CategoryRule{}
+	}
+	payload, err := json.Marshal(rules)
 	if err != nil {
-		return err
+		return false, fmt.Errorf("error marshaling data: %v", err)
 	}
 
-	// 3. Push to HTTP service
+	resp, err := d.client.Do(ctx, http.MethodPut, d.url+"/v1/categories/rules", "application/json", payload)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
 
-	var bucketCount int
+	var result struct{ Changed bool }
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return false, fmt.Errorf("error unmarshalling response: %v", err)
+	}
 
-	// Send buckets to HTTP
-	for _, bucket := range buckets {
-		err := sendToHTTP(url+"/buckets", bucket)
-		if err != nil {
-			return err
-		}
-		bucketCount++
+	return result.Changed, nil
+}
+
+// RefreshEventCategories asks the service to categorise the events written since the last refresh
+func (d *Destination) RefreshEventCategories(ctx context.Context) (int, error) {
+	return postForCount(ctx, d.client, d.url+"/v1/categories:refresh")
+}
+
+// RecategorizeEvents asks the service to categorise every event again
+func (d *Destination) RecategorizeEvents(ctx context.Context) (int, error) {
+	return postForCount(ctx, d.client, d.url+"/v1/categories:recompute")
+}
+
+// SetScoring replaces the category weights in the service, reporting whether they changed
+func (d *Destination) SetScoring(ctx context.Context, scoring data.Scoring) (bool, error) {
+	payload, err := json.Marshal(scoring)
+	if err != nil {
+		return false, fmt.Errorf("error marshaling data: %v", err)
 	}
 
-	log.Printf("wrote %v buckets to the HTTP service", bucketCount)
+	resp, err := d.client.Do(ctx, http.MethodPut, d.url+"/v1/scoring", "application/json", payload)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	var result struct{ Changed bool }
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return false, fmt.Errorf("error unmarshalling response: %v", err)
+	}
 
-	// Use a WaitGroup to handle concurrency for events
-	var wg sync.WaitGroup
-	eventCh := make(chan data.Event, concurrency)
+	return result.Changed, nil
+}
 
-	// Worker function to process events in parallel
-	worker := func(eventCh <-chan data.Event, wg *sync.WaitGroup) {
-		defer wg.Done()
-		for event := range eventCh {
-			err := sendToHTTP(url+"/events", event)
-			if err != nil {
-				log.Printf("Error uploading event: %v", err)
-			}
-		}
+// RefreshDailyScores asks the service to score the days changed since the last refresh
+func (d *Destination) RefreshDailyScores(ctx context.Context) (int, error) {
+	return postForCount(ctx, d.client, d.url+"/v1/scores:refresh")
+}
+
+// Report reads the scores, top apps and sites and longest focus streak from start to end
+func (d *Destination) Report(ctx context.Context, start, end time.Time, top int) (data.Report, error) {
+	query := url.Values{}
+	query.Set("start", start.Format(time.RFC3339))
+	query.Set("end", end.Format(time.RFC3339))
+	query.Set("tz", start.Location().String())
+	query.Set("top", strconv.Itoa(top))
+
+	var report data.Report
+	resp, err := d.client.Do(ctx, http.MethodGet, d.url+"/v1/report?"+query.Encode(), "", nil)
+	if err != nil {
+		return report, err
 	}
+	defer resp.Body.Close()
 
-	// Start workers
-	for i := 0; i < concurrency; i++ {
-		wg.Add(1)
-		go worker(eventCh, &wg)
+	err = json.NewDecoder(resp.Body).Decode(&report)
+	if err != nil {
+		return report, fmt.Errorf("error unmarshalling report: %v", err)
 	}
 
-	// Send events to workers
-	for _, event := range events {
-		eventCh <- event
+	return report, nil
+}
+
+// SetSessionSettings replaces how the service finds sessions, reporting whether that changed
+func (d *Destination) SetSessionSettings(ctx context.Context, settings data.SessionSettings) (bool, error) {
+	payload, err := json.Marshal(settings)
+	if err != nil {
+		return false, fmt.Errorf("error marshaling data: %v", err)
 	}
 
-	// Close the channel after sending all events
-	close(eventCh)
+	resp, err := d.client.Do(ctx, http.MethodPut, d.url+"/v1/sessions/settings", "application/json", payload)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
 
-	// Wait for all workers to finish processing
-	wg.Wait()
+	var result struct{ Changed bool }
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return false, fmt.Errorf("error unmarshalling response: %v", err)
+	}
 
-	log.Printf("Successfully loaded %d buckets and %d events to the HTTP service", len(buckets), len(events))
-	return nil
+	return result.Changed, nil
 }
 
-func HttpSync(ctx context.Context, sourcePath, connString string, interval int) error {
-	var events []data.Event
-	var buckets []data.Bucket
+// RefreshSessions asks the service to find the sessions around the activity written since the last refresh
+func (d *Destination) RefreshSessions(ctx context.Context) (int, error) {
+	return postForCount(ctx, d.client, d.url+"/v1/sessions:refresh")
+}
 
-	// Normalize to UTC for consistency
-	currentTime := time.Now().UTC()
-	cutoffTime := currentTime.Add(-time.Duration(interval) * time.Second)
+// ListSessions reads the sessions overlapping start to end
+func (d *Destination) ListSessions(ctx context.Context, start, end time.Time) ([]data.Session, error) {
+	query := url.Values{}
+	query.Set("start", start.Format(time.RFC3339))
+	query.Set("end", end.Format(time.RFC3339))
 
-	// Open the SQLite connection
-	sqliteConn, err := sqlite.OpenConn(sourcePath, sqlite.OpenReadOnly)
+	resp, err := d.client.Do(ctx, http.MethodGet, d.url+"/v1/sessions?"+query.Encode(), "", nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer sqliteConn.Close()
-
-	// Read buckets from SQLite database
-	err = sqlitex.ExecuteTransient(sqliteConn, "SELECT * FROM bucketmodel WHERE created >= ?;", &sqlitex.ExecOptions{
-		Args: []interface{}{cutoffTime.Format("2006-01-02T15:04:05.999999")},
-		ResultFunc: func(stmt *sqlite.Stmt) error {
-			var bucket data.Bucket
-
-			parsedTime, err := time.Parse("2006-01-02T15:04:05.999999", stmt.ColumnText(2))
-			if err != nil {
-				log.Println("Error parsing bucket time:", stmt.ColumnText(2))
-				return err
-			}
-
-			bucket.Key = stmt.ColumnInt(0)
-			bucket.ID = stmt.ColumnText(1)
-			bucket.Created = parsedTime
-			bucket.Name = stmt.ColumnText(3)
-			bucket.Type = stmt.ColumnText(4)
-			bucket.Client = stmt.ColumnText(5)
-			bucket.Hostname = stmt.ColumnText(6)
-
-			buckets = append(buckets, bucket)
-			return nil
-		},
-	})
+	defer resp.Body.Close()
+
+	var sessions []data.Session
+	err = json.NewDecoder(resp.Body).Decode(&sessions)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("error unmarshalling sessions: %v", err)
 	}
 
-	// Read events from SQLite database
-	err = sqlitex.ExecuteTransient(sqliteConn, "SELECT * FROM eventmodel WHERE timestamp >= ?;", &sqlitex.ExecOptions{
-		Args: []interface{}{cutoffTime.Format("2006-01-02 15:04:05.999999-07:00")},
-		ResultFunc: func(stmt *sqlite.Stmt) error {
-			var event data.Event
-
-			parsedTime, err := time.Parse("2006-01-02 15:04:05.999999-07:00", stmt.ColumnText(2))
-			if err != nil {
-				log.Println("Error parsing event time")
-				return err
-			}
-
-			event.ID = stmt.ColumnInt(0)
-			event.BucketID = stmt.ColumnInt(1)
-			event.Timestamp = parsedTime
-			event.Duration = stmt.ColumnFloat(3)
-			event.DataStr = stmt.ColumnText(4)
-
-			events = append(events, event)
-			return nil
-		},
-	})
+	return sessions, nil
+}
+
+// RefreshAppSwitches asks the service to find the app switches among the events written since the last refresh
+func (d *Destination) RefreshAppSwitches(ctx context.Context) (int, error) {
+	return postForCount(ctx, d.client, d.url+"/v1/switches:refresh")
+}
+
+// Switches reads the app switches from start to end per hour of the day and the top transitions
+func (d *Destination) Switches(ctx context.Context, start, end time.Time, top int) (data.SwitchReport, error) {
+	query := url.Values{}
+	query.Set("start", start.Format(time.RFC3339))
+	query.Set("end", end.Format(time.RFC3339))
+	query.Set("tz", start.Location().String())
+	query.Set("top", strconv.Itoa(top))
+
+	var report data.SwitchReport
+	resp, err := d.client.Do(ctx, http.MethodGet, d.url+"/v1/switches?"+query.Encode(), "", nil)
 	if err != nil {
-		return err
+		return report, err
 	}
+	defer resp.Body.Close()
 
-	// Push buckets to the HTTP service
-	for _, bucket := range buckets {
-		err := sendToHTTP(connString+"/buckets", bucket)
-		if err != nil {
-			log.Printf("Error sending bucket to HTTP service: %v", err)
-		}
+	err = json.NewDecoder(resp.Body).Decode(&report)
+	if err != nil {
+		return report, fmt.Errorf("error unmarshalling switches: %v", err)
 	}
 
-	// Push events to the HTTP service concurrently
-	const workerCount = 1000
-	var wg sync.WaitGroup
-	eventCh := make(chan data.Event, workerCount)
+	return report, nil
+}
+
+// GoalUsage reads the active seconds from start to end spent on target, an app, site or category depending on kind
+func (d *Destination) GoalUsage(ctx context.Context, kind, target string, start, end time.Time) (float64, error) {
+	query := url.Values{}
+	query.Set("kind", kind)
+	query.Set("target", target)
+	query.Set("start", start.Format(time.RFC3339))
+	query.Set("end", end.Format(time.RFC3339))
 
-	worker := func(eventCh <-chan data.Event, wg *sync.WaitGroup) {
-		defer wg.Done()
-		for event := range eventCh {
-			if err := sendToHTTP(connString+"/events", event); err != nil {
-				log.Printf("Error sending event to HTTP service: %v", err)
-			}
-		}
+	resp, err := d.client.Do(ctx, http.MethodGet, d.url+"/v1/usage?"+query.Encode(), "", nil)
+	if err != nil {
+		return 0, err
 	}
+	defer resp.Body.Close()
 
-	// Start worker goroutines
-	for i := 0; i < workerCount; i++ {
-		wg.Add(1)
-		go worker(eventCh, &wg)
+	var result struct{ Seconds float64 }
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return 0, fmt.Errorf("error unmarshalling usage: %v", err)
 	}
 
-	// Send events to workers
-	for _, event := range events {
-		eventCh <- event
+	return result.Seconds, nil
+}
+
+// Helper function to send items to a batch endpoint as newline-delimited JSON,
+// decoding the JSON response into out unless it is nil
+func sendBatch[T any](ctx context.Context, client *Client, endpoint string, items []T, out any) error {
+	var payload bytes.Buffer
+	encoder := json.NewEncoder(&payload)
+	for _, item := range items {
+		if err := encoder.Encode(item); err != nil {
+			return fmt.Errorf("error marshaling data: %v", err)
+		}
 	}
-	close(eventCh)
 
-	wg.Wait()
+	resp, err := client.Do(ctx, http.MethodPost, endpoint, "application/x-ndjson", payload.Bytes())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		err = json.NewDecoder(resp.Body).Decode(out)
+		if err != nil {
+			return fmt.Errorf("error unmarshalling response: %v", err)
+		}
+	}
 
-	log.Printf("Successfully synced %d buckets and %d events to the HTTP service", len(buckets), len(events))
 	return nil
 }
 
-// Helper function to send data to the HTTP service
-func sendToHTTP(endpoint string, data any) error {
-	// Marshal data into JSON
-	payload, err := json.Marshal(data)
+// Helper function to trigger a job in the HTTP service, returning how many rows it wrote
+func postForCount(ctx context.Context, client *Client, endpoint string) (int, error) {
+	resp, err := client.Do(ctx, http.MethodPost, endpoint, "", nil)
 	if err != nil {
-		return fmt.Errorf("error marshaling data: %v", err)
+		return 0, err
 	}
+	defer resp.Body.Close()
 
-	// Create HTTP POST request
-	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(payload))
+	var result struct{ Written int }
+	err = json.NewDecoder(resp.Body).Decode(&result)
 	if err != nil {
-		return fmt.Errorf("error creating HTTP request: %v", err)
+		return 0, fmt.Errorf("error unmarshalling response: %v", err)
 	}
 
-	// Set content-type header
-	req.Header.Set("Content-Type", "application/json")
+	return result.Written, nil
+}
 
-	// Make the HTTP request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// Helper function to read the watermarks from the HTTP service
+func fetchSyncStates(ctx context.Context, client *Client, endpoint string) (map[int]data.SyncState, error) {
+	resp, err := client.Do(ctx, http.MethodGet, endpoint, "", nil)
 	if err != nil {
-		return fmt.Errorf("error making HTTP request: %v", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	// Check for successful response
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP request failed with status: %v", resp.Status)
+	var list []data.SyncState
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling sync state: %v", err)
+	}
+
+	states := make(map[int]data.SyncState, len(list))
+	for _, state := range list {
+		states[state.BucketKey] = state
+	}
+
+	return states, nil
+}
+
+// Helper function to send data to the HTTP service
+func sendToHTTP(ctx context.Context, client *Client, endpoint string, data any) error {
+	// Marshal data into JSON
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("error marshaling data: %v", err)
+	}
+
+	// Make the HTTP request, retrying if the service is unavailable
+	resp, err := client.Do(ctx, http.MethodPost, endpoint, "application/json", payload)
+	if err != nil {
+		return err
 	}
+	resp.Body.Close()
 
 	return nil
 }
@@ -0,0 +1,72 @@
+package pipeline
+
+import (
+	"context"
+	"log"
+
+	"github.com/azaurus1/lifevisor/internal/data"
+)
+
+// Categorizer is a Sink sorting the window and tab events written to it into categories by rules
+type Categorizer interface {
+	SetCategoryRules(ctx context.Context, rules []data.CategoryRule) (bool, error)
+	RefreshEventCategories(ctx context.Context) (int, error)
+}
+
+// Recategorizer is a Categorizer that can categorise everything it holds again
+type Recategorizer interface {
+	Categorizer
+	RecategorizeEvents(ctx context.Context) (int, error)
+}
+
+// Recategorize stores rules in dest unless they are nil, then categorises every event again.
+// It returns how many events changed category.
+func Recategorize(ctx context.Context, dest Recategorizer, rules []data.CategoryRule) (int, error) {
+	if rules != nil {
+		_, err := dest.SetCategoryRules(ctx, rules)
+		if err != nil {
+			return 0, &DestinationError{Err: err}
+		}
+	}
+
+	n, err := dest.RecategorizeEvents(ctx)
+	if err != nil {
+		return 0, &DestinationError{Err: err}
+	}
+
+	return n, nil
+}
+
+// setCategoryRules stores rules in sink and reports whether they changed. A failure leaves the
+// previous rules in place and the events are still written, so errors are only logged.
+func setCategoryRules(ctx context.Context, sink Sink, rules []data.CategoryRule) bool {
+	categorizer, ok := sink.(Categorizer)
+	if !ok {
+		return false
+	}
+
+	changed, err := categorizer.SetCategoryRules(ctx, rules)
+	if err != nil {
+		log.Printf("Error storing %d category rules: %v", len(rules), err)
+		return false
+	}
+	if changed {
+		log.Printf("Category rules changed, every event will be categorised again")
+	}
+	return changed
+}
+
+// refreshCategories categorises the events written to sink, errors are caught up by the next refresh
+func refreshCategories(ctx context.Context, sink Sink) {
+	categorizer, ok := sink.(Categorizer)
+	if !ok {
+		return
+	}
+
+	n, err := categorizer.RefreshEventCategories(ctx)
+	if err != nil {
+		log.Printf("Error categorising events: %v", err)
+		return
+	}
+	log.Printf("Categorised %d events", n)
+}
@@ -0,0 +1,109 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// SourceError is an error reading from ActivityWatch
+type SourceError struct {
+	Err error
+}
+
+func (e *SourceError) Error() string { return "source: " + e.Err.Error() }
+func (e *SourceError) Unwrap() error { return e.Err }
+
+// DestinationError is an error writing to PostgreSQL or lifevisor-service
+type DestinationError struct {
+	Err error
+}
+
+func (e *DestinationError) Error() string { return "destination: " + e.Err.Error() }
+func (e *DestinationError) Unwrap() error { return e.Err }
+
+// FailureCategory groups write failures by what can be done about them
+type FailureCategory string
+
+const (
+	// the destination could not be reached or failed on its side, retrying later should work
+	Unavailable FailureCategory = "unavailable"
+	// the destination refused the data, retrying will fail the same way
+	Rejected FailureCategory = "rejected"
+	Unknown  FailureCategory = "unknown"
+)
+
+// retryable is implemented by errors that know whether the destination may accept a retry
+type retryable interface {
+	Retryable() bool
+}
+
+func categorize(err error) FailureCategory {
+	var r retryable
+	if errors.As(err, &r) {
+		if r.Retryable() {
+			return Unavailable
+		}
+		return Rejected
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// connection exceptions, insufficient resources and operator intervention
+		if strings.HasPrefix(pgErr.Code, "08") || strings.HasPrefix(pgErr.Code, "53") || strings.HasPrefix(pgErr.Code, "57") {
+			return Unavailable
+		}
+		return Rejected
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || pgconn.SafeToRetry(err) {
+		return Unavailable
+	}
+
+	return Unknown
+}
+
+// maxErrors is how many write errors a run keeps for reporting
+const maxErrors = 5
+
+// Failures collects the events a run could not write
+type Failures struct {
+	Events     int
+	Categories map[FailureCategory]int // failed events per category
+	Errors     []error                 // the first maxErrors errors
+}
+
+func (f *Failures) add(err error, events int) {
+	if f.Categories == nil {
+		f.Categories = make(map[FailureCategory]int)
+	}
+	f.Events += events
+	f.Categories[categorize(err)] += events
+	if len(f.Errors) < maxErrors {
+		f.Errors = append(f.Errors, err)
+	}
+}
+
+// FailureError is returned when more events failed to write than the run tolerates
+type FailureError struct {
+	Stats Stats
+}
+
+func (e *FailureError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d of %d events failed to write", e.Stats.Failures.Events, e.Stats.Read)
+	for _, category := range []FailureCategory{Unavailable, Rejected, Unknown} {
+		if n := e.Stats.Failures.Categories[category]; n > 0 {
+			fmt.Fprintf(&b, ", %d %s", n, category)
+		}
+	}
+	if len(e.Stats.Failures.Errors) > 0 {
+		fmt.Fprintf(&b, " (first error: %v)", e.Stats.Failures.Errors[0])
+	}
+	return b.String()
+}
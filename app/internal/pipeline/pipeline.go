@@ -0,0 +1,309 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/azaurus1/lifevisor/internal/data"
+	"github.com/azaurus1/lifevisor/internal/deadletter"
+	"github.com/azaurus1/lifevisor/internal/source"
+)
+
+// Sink is a destination the pipeline writes to, either PostgreSQL or lifevisor-service
+type Sink interface {
+	GetSyncStates(ctx context.Context, deviceID string) (map[int]data.SyncState, error)
+	InsertBuckets(ctx context.Context, buckets []data.Bucket) error
+	InsertEvents(ctx context.Context, events []data.Event) (data.WriteResult, error)
+	UpdateSyncState(ctx context.Context, state data.SyncState) error
+}
+
+// ActiveRefresher is a Sink deriving the active time of window events from the AFK events written to it
+type ActiveRefresher interface {
+	RefreshActiveEvents(ctx context.Context) (int, error)
+}
+
+type Options struct {
+	BatchSize int  // events written per InsertEvents call
+	Progress  bool // print rows read and written to stderr, and count the rows skipped by resuming
+	// share of the events read that may fail to write before Run returns a FailureError,
+	// the default of 0 fails the run on any write error
+	MaxFailureRatio float64
+	// keeps the buckets and events that failed to write for retry-failed, optional
+	DeadLetter *deadletter.Store
+	// categorisation rules to store in the sink before writing, nil leaves the stored rules alone
+	Categories []data.CategoryRule
+	// category weights to store in the sink before writing, nil leaves the stored ones alone
+	Scoring *data.Scoring
+	// how to find sessions, nil leaves the stored settings alone
+	Sessions *data.SessionSettings
+}
+
+type Stats struct {
+	Buckets   int
+	Read      int
+	Written   int
+	Inserted  int
+	Updated   int
+	Unchanged int // written events the destination already had
+	Resumed   int // events before the watermarks that were not read at all
+	Failures  Failures
+}
+
+// Summary describes what a run did with the events it read
+func (s Stats) Summary() string {
+	return fmt.Sprintf("%d buckets, %d events read: %d inserted, %d updated, %d skipped as unchanged, %d skipped by resuming, %d failed",
+		s.Buckets, s.Read, s.Inserted, s.Updated, s.Unchanged, s.Resumed, s.Failures.Events)
+}
+
+// Run streams every bucket and the events past their watermarks in states from src into sink.
+// The watermarks of buckets written without errors are advanced after every batch, so they
+// double as checkpoints an interrupted run can be resumed from by passing them back in states.
+// At most two batches are buffered between reading and writing, so memory use does not
+// grow with the size of the history.
+func Run(ctx context.Context, src source.Source, sink Sink, states map[int]data.SyncState, opts Options) (Stats, error) {
+	var stats Stats
+
+	it, err := src.Buckets(ctx, time.Time{})
+	if err != nil {
+		return stats, &SourceError{Err: err}
+	}
+	buckets, err := source.Collect(it)
+	if err != nil {
+		return stats, &SourceError{Err: err}
+	}
+
+	// writes outlive a cancelled ctx, so a stop lets the batch in flight and its checkpoint land,
+	// but keep its deadline
+	writeCtx := context.WithoutCancel(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancelWrites context.CancelFunc
+		writeCtx, cancelWrites = context.WithDeadline(writeCtx, deadline)
+		defer cancelWrites()
+	}
+
+	// inserting buckets again is idempotent
+	err = sink.InsertBuckets(writeCtx, buckets)
+	if err != nil {
+		deadLetterBuckets(opts.DeadLetter, buckets, err)
+		return stats, &DestinationError{Err: err}
+	}
+	stats.Buckets = len(buckets)
+
+	recategorize := opts.Categories != nil && setCategoryRules(writeCtx, sink, opts.Categories)
+	rescore := opts.Scoring != nil && setScoring(writeCtx, sink, *opts.Scoring)
+	resession := opts.Sessions != nil && setSessionSettings(writeCtx, sink, *opts.Sessions)
+
+	var prog *progress
+	if opts.Progress {
+		total := countEvents(ctx, src, buckets, states)
+		if total >= 0 && len(states) > 0 {
+			stats.Resumed = max(countEvents(ctx, src, buckets, nil)-total, 0)
+		}
+		prog = startProgress(total)
+		defer prog.stop()
+	}
+
+	readCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	batches := make(chan []data.Event, 2)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(batches)
+		readErr <- readBatches(readCtx, src, buckets, states, opts.BatchSize, batches, prog)
+	}()
+
+	// events of a bucket arrive in id order, so the highest written id is a safe watermark
+	current := make(map[int]data.SyncState, len(states))
+	for key, state := range states {
+		current[key] = state
+	}
+	failed := make(map[int]bool)
+
+	for batch := range batches {
+		stats.Read += len(batch)
+
+		result, err := sink.InsertEvents(writeCtx, batch)
+		if err != nil {
+			log.Printf("Error writing %d events: %v", len(batch), err)
+			stats.Failures.add(err, len(batch))
+			deadLetterEvents(opts.DeadLetter, batch, err)
+			for _, event := range batch {
+				failed[event.BucketID] = true
+			}
+			continue
+		}
+
+		stats.Written += len(batch)
+		stats.Inserted += result.Inserted
+		stats.Updated += result.Updated
+		stats.Unchanged += len(batch) - result.Inserted - result.Updated
+		prog.wrote(len(batch))
+
+		// checkpoint
+		for key, state := range data.NextSyncStates(current, batch) {
+			current[key] = state
+			if failed[key] {
+				continue
+			}
+
+			err := sink.UpdateSyncState(writeCtx, state)
+			if err != nil {
+				// stop the reader and wait for it before giving up
+				cancel()
+				for range batches {
+				}
+				<-readErr
+				return stats, &DestinationError{Err: err}
+			}
+		}
+	}
+
+	for key := range failed {
+		log.Printf("Not advancing watermark of bucket %d after failed writes", key)
+	}
+
+	if stats.Written > 0 {
+		refreshActive(writeCtx, sink)
+		refreshSwitches(writeCtx, sink)
+	}
+	if stats.Written > 0 || recategorize {
+		refreshCategories(writeCtx, sink)
+	}
+	if stats.Written > 0 || recategorize || rescore {
+		refreshScores(writeCtx, sink)
+	}
+	if stats.Written > 0 || recategorize || resession {
+		refreshSessions(writeCtx, sink)
+	}
+
+	if err := <-readErr; err != nil {
+		return stats, &SourceError{Err: err}
+	}
+
+	if stats.Failures.Events > 0 && float64(stats.Failures.Events) > opts.MaxFailureRatio*float64(stats.Read) {
+		return stats, &DestinationError{Err: &FailureError{Stats: stats}}
+	}
+
+	return stats, nil
+}
+
+// refreshActive brings the active time of sink up to date, the written events are safe
+// either way so errors are only logged and caught up by the next refresh
+func refreshActive(ctx context.Context, sink Sink) {
+	refresher, ok := sink.(ActiveRefresher)
+	if !ok {
+		return
+	}
+
+	n, err := refresher.RefreshActiveEvents(ctx)
+	if err != nil {
+		log.Printf("Error refreshing active time: %v", err)
+		return
+	}
+	log.Printf("Refreshed %d active time segments", n)
+}
+
+// deadLetterBuckets keeps buckets in store, the run has failed already so errors are only logged
+func deadLetterBuckets(store *deadletter.Store, buckets []data.Bucket, cause error) {
+	if store == nil {
+		return
+	}
+	if err := store.AddBuckets(buckets, cause); err != nil {
+		log.Printf("Error writing %d buckets to the dead-letter store: %v", len(buckets), err)
+	}
+}
+
+// deadLetterEvents keeps events in store, their watermarks stay put either way so the next sync reads them again
+func deadLetterEvents(store *deadletter.Store, events []data.Event, cause error) {
+	if store == nil {
+		return
+	}
+	if err := store.AddEvents(events, cause); err != nil {
+		log.Printf("Error writing %d events to the dead-letter store: %v", len(events), err)
+	}
+}
+
+// readBatches sends the events of every bucket from its watermark on in batches of batchSize
+func readBatches(ctx context.Context, src source.Source, buckets []data.Bucket, states map[int]data.SyncState, batchSize int, batches chan<- []data.Event, prog *progress) error {
+	batch := make([]data.Event, 0, batchSize)
+
+	send := func() error {
+		select {
+		case batches <- batch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		prog.read(len(batch))
+		batch = make([]data.Event, 0, batchSize)
+		return nil
+	}
+
+	for _, bucket := range buckets {
+		it, err := src.Events(ctx, source.From(bucket, states[bucket.Key]))
+		if err != nil {
+			return err
+		}
+
+		for it.Next() {
+			batch = append(batch, it.Value())
+			if len(batch) < batchSize {
+				continue
+			}
+			if err := send(); err != nil {
+				it.Close()
+				return err
+			}
+		}
+		it.Close()
+
+		if err := it.Err(); err != nil {
+			return err
+		}
+	}
+
+	if len(batch) > 0 {
+		return send()
+	}
+	return nil
+}
+
+// countEvents returns how many events Run will read, or -1 if the source cannot tell up front
+func countEvents(ctx context.Context, src source.Source, buckets []data.Bucket, states map[int]data.SyncState) int {
+	counter, ok := src.(source.Counter)
+	if !ok {
+		return -1
+	}
+
+	var total int
+	for _, bucket := range buckets {
+		n, err := counter.CountEvents(ctx, source.From(bucket, states[bucket.Key]))
+		if err != nil {
+			log.Printf("Error counting events: %v", err)
+			return -1
+		}
+		total += n
+	}
+
+	return total
+}
+
+// SyncBatchSize is the batch size of incremental syncs, batches this large are bulk copied into PostgreSQL
+const SyncBatchSize = 1000
+
+// Sync pushes everything past the watermarks deviceID has in sink, opts.BatchSize defaults to SyncBatchSize
+func Sync(ctx context.Context, src source.Source, sink Sink, deviceID string, opts Options) (Stats, error) {
+	if opts.BatchSize == 0 {
+		opts.BatchSize = SyncBatchSize
+	}
+
+	// Load the high-water mark of every bucket
+	states, err := sink.GetSyncStates(ctx, deviceID)
+	if err != nil {
+		return Stats{}, &DestinationError{Err: err}
+	}
+
+	return Run(ctx, src, sink, states, opts)
+}
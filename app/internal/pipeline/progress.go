@@ -0,0 +1,84 @@
+package pipeline
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// progress prints rows read and written to stderr once a second, a nil progress prints nothing
+type progress struct {
+	total       int64 // -1 when unknown
+	start       time.Time
+	readRows    atomic.Int64
+	writtenRows atomic.Int64
+	done        chan struct{}
+	stopped     chan struct{}
+}
+
+func startProgress(total int) *progress {
+	p := &progress{
+		total:   int64(total),
+		start:   time.Now(),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(p.stopped)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.print()
+			case <-p.done:
+				p.print()
+				fmt.Fprintln(os.Stderr)
+				return
+			}
+		}
+	}()
+
+	return p
+}
+
+func (p *progress) read(n int) {
+	if p != nil {
+		p.readRows.Add(int64(n))
+	}
+}
+
+func (p *progress) wrote(n int) {
+	if p != nil {
+		p.writtenRows.Add(int64(n))
+	}
+}
+
+func (p *progress) stop() {
+	close(p.done)
+	<-p.stopped
+}
+
+func (p *progress) print() {
+	read := p.readRows.Load()
+	written := p.writtenRows.Load()
+	elapsed := time.Since(p.start)
+	rate := float64(written) / elapsed.Seconds()
+
+	if p.total < 0 {
+		fmt.Fprintf(os.Stderr, "\rread %d written %d (%.0f rows/s)   ", read, written, rate)
+		return
+	}
+
+	var percent float64
+	if p.total > 0 {
+		percent = 100 * float64(written) / float64(p.total)
+	}
+	eta := "?"
+	if rate > 0 {
+		eta = (time.Duration(float64(p.total-written)/rate) * time.Second).Round(time.Second).String()
+	}
+	fmt.Fprintf(os.Stderr, "\rread %d/%d written %d (%.1f%%, %.0f rows/s, ETA %s)   ", read, p.total, written, percent, rate, eta)
+}
@@ -0,0 +1,142 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/azaurus1/lifevisor/internal/data"
+	"github.com/azaurus1/lifevisor/internal/deadletter"
+)
+
+type ReplayStats struct {
+	Buckets    int // buckets written
+	Events     int // events written
+	Superseded int // events a later sync had written already, dropped without sending
+	Failed     int // buckets and events left in the store
+}
+
+// Summary describes what a replay did with the dead-lettered rows
+func (s ReplayStats) Summary() string {
+	return fmt.Sprintf("%d buckets and %d events replayed, %d already synced since, %d still failing",
+		s.Buckets, s.Events, s.Superseded, s.Failed)
+}
+
+// Replay writes the buckets and events of deviceID in store to sink and removes the ones that were written.
+// Events at or below the watermark of their bucket are dropped unsent, a later sync has written
+// a copy at least as recent. Entries of other devices are left alone.
+func Replay(ctx context.Context, store *deadletter.Store, sink Sink, deviceID string) (ReplayStats, error) {
+	var stats ReplayStats
+
+	entries, err := store.Load()
+	if err != nil {
+		return stats, err
+	}
+
+	var keep []deadletter.Entry
+	var buckets []deadletter.Entry
+	var events []deadletter.Entry
+	for _, entry := range entries {
+		switch {
+		case entry.DeviceID() != deviceID:
+			keep = append(keep, entry)
+		case entry.Bucket != nil:
+			buckets = append(buckets, entry)
+		default:
+			events = append(events, entry)
+		}
+	}
+
+	// 1. buckets first, events reference them
+	if len(buckets) > 0 {
+		list := make([]data.Bucket, len(buckets))
+		for i, entry := range buckets {
+			list[i] = *entry.Bucket
+		}
+
+		err := sink.InsertBuckets(ctx, list)
+		if err != nil {
+			// the events would fail on the missing buckets, leave everything for the next try
+			stats.Failed = len(buckets) + len(events)
+			if addErr := store.AddBuckets(list, err); addErr != nil {
+				log.Printf("Error updating the dead-letter store: %v", addErr)
+			}
+			return stats, &DestinationError{Err: err}
+		}
+		stats.Buckets = len(buckets)
+	}
+
+	// 2. drop the events synced since
+	states, err := sink.GetSyncStates(ctx, deviceID)
+	if err != nil {
+		return stats, &DestinationError{Err: err}
+	}
+
+	var pending []data.Event
+	for _, entry := range events {
+		if entry.Event.ID <= states[entry.Event.BucketID].LastEventID {
+			stats.Superseded++
+			continue
+		}
+		pending = append(pending, *entry.Event)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].BucketID != pending[j].BucketID {
+			return pending[i].BucketID < pending[j].BucketID
+		}
+		return pending[i].ID < pending[j].ID
+	})
+
+	// 3. write the rest in batches, the watermarks are left to the next sync which reads
+	// these events again from the source if it still has them
+	var failures Failures
+	retry := make(map[[2]int]error) // bucket and event id of the events that failed again
+
+	for start := 0; start < len(pending); start += SyncBatchSize {
+		batch := pending[start:min(start+SyncBatchSize, len(pending))]
+
+		_, err := sink.InsertEvents(ctx, batch)
+		if err != nil {
+			log.Printf("Error replaying %d events: %v", len(batch), err)
+			failures.add(err, len(batch))
+			for _, event := range batch {
+				retry[[2]int{event.BucketID, event.ID}] = err
+			}
+			continue
+		}
+		stats.Events += len(batch)
+	}
+
+	if stats.Events > 0 {
+		refreshActive(ctx, sink)
+		refreshSwitches(ctx, sink)
+		refreshCategories(ctx, sink)
+		refreshScores(ctx, sink)
+		refreshSessions(ctx, sink)
+	}
+
+	// 4. keep what failed again, counting the attempt
+	now := time.Now().UTC()
+	for _, entry := range events {
+		if err, ok := retry[[2]int{entry.Event.BucketID, entry.Event.ID}]; ok {
+			entry.Attempts++
+			entry.Error = err.Error()
+			entry.LastFailed = now
+			keep = append(keep, entry)
+		}
+	}
+	stats.Failed = failures.Events
+
+	err = store.Replace(entries, keep)
+	if err != nil {
+		return stats, err
+	}
+
+	if failures.Events > 0 {
+		return stats, &DestinationError{Err: &FailureError{Stats: Stats{Read: len(pending), Failures: failures}}}
+	}
+
+	return stats, nil
+}
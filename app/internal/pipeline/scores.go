@@ -0,0 +1,48 @@
+package pipeline
+
+import (
+	"context"
+	"log"
+
+	"github.com/azaurus1/lifevisor/internal/data"
+)
+
+// Scorer is a Sink keeping daily productivity scores of the active time written to it
+type Scorer interface {
+	SetScoring(ctx context.Context, scoring data.Scoring) (bool, error)
+	RefreshDailyScores(ctx context.Context) (int, error)
+}
+
+// setScoring stores scoring in sink and reports whether it changed, errors are only logged
+// like those of setCategoryRules
+func setScoring(ctx context.Context, sink Sink, scoring data.Scoring) bool {
+	scorer, ok := sink.(Scorer)
+	if !ok {
+		return false
+	}
+
+	changed, err := scorer.SetScoring(ctx, scoring)
+	if err != nil {
+		log.Printf("Error storing %d category weights: %v", len(scoring.Weights), err)
+		return false
+	}
+	if changed {
+		log.Printf("Category weights changed, every day will be scored again")
+	}
+	return changed
+}
+
+// refreshScores brings the daily scores of sink up to date, errors are caught up by the next refresh
+func refreshScores(ctx context.Context, sink Sink) {
+	scorer, ok := sink.(Scorer)
+	if !ok {
+		return
+	}
+
+	n, err := scorer.RefreshDailyScores(ctx)
+	if err != nil {
+		log.Printf("Error refreshing daily scores: %v", err)
+		return
+	}
+	log.Printf("Scored %d days", n)
+}
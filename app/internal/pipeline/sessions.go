@@ -0,0 +1,48 @@
+package pipeline
+
+import (
+	"context"
+	"log"
+
+	"github.com/azaurus1/lifevisor/internal/data"
+)
+
+// Sessionizer is a Sink finding focus sessions in the active time written to it
+type Sessionizer interface {
+	SetSessionSettings(ctx context.Context, settings data.SessionSettings) (bool, error)
+	RefreshSessions(ctx context.Context) (int, error)
+}
+
+// setSessionSettings stores settings in sink and reports whether they changed, errors are only
+// logged like those of setCategoryRules
+func setSessionSettings(ctx context.Context, sink Sink, settings data.SessionSettings) bool {
+	sessionizer, ok := sink.(Sessionizer)
+	if !ok {
+		return false
+	}
+
+	changed, err := sessionizer.SetSessionSettings(ctx, settings)
+	if err != nil {
+		log.Printf("Error storing session settings: %v", err)
+		return false
+	}
+	if changed {
+		log.Printf("Session settings changed, every session will be found again")
+	}
+	return changed
+}
+
+// refreshSessions brings the sessions of sink up to date, errors are caught up by the next refresh
+func refreshSessions(ctx context.Context, sink Sink) {
+	sessionizer, ok := sink.(Sessionizer)
+	if !ok {
+		return
+	}
+
+	n, err := sessionizer.RefreshSessions(ctx)
+	if err != nil {
+		log.Printf("Error refreshing sessions: %v", err)
+		return
+	}
+	log.Printf("Found %d sessions", n)
+}
@@ -0,0 +1,26 @@
+package pipeline
+
+import (
+	"context"
+	"log"
+)
+
+// SwitchRefresher is a Sink finding the app switches among the window events written to it
+type SwitchRefresher interface {
+	RefreshAppSwitches(ctx context.Context) (int, error)
+}
+
+// refreshSwitches brings the app switches of sink up to date, errors are caught up by the next refresh
+func refreshSwitches(ctx context.Context, sink Sink) {
+	refresher, ok := sink.(SwitchRefresher)
+	if !ok {
+		return
+	}
+
+	n, err := refresher.RefreshAppSwitches(ctx)
+	if err != nil {
+		log.Printf("Error refreshing app switches: %v", err)
+		return
+	}
+	log.Printf("Found %d app switches", n)
+}
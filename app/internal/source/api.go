@@ -0,0 +1,252 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/azaurus1/lifevisor/internal/data"
+)
+
+// API reads from the REST API of a running aw-server, e.g. http://localhost:5600
+type API struct {
+	BaseURL  string
+	Client   *http.Client
+	PageSpan time.Duration // events are requested one window of this length at a time
+	deviceID string
+}
+
+func NewAPI(baseURL, deviceID string) *API {
+	return &API{
+		BaseURL:  baseURL,
+		Client:   &http.Client{Timeout: 60 * time.Second},
+		PageSpan: 24 * time.Hour,
+		deviceID: deviceID,
+	}
+}
+
+func (a *API) Close() error {
+	return nil
+}
+
+type apiBucket struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Client   string    `json:"client"`
+	Hostname string    `json:"hostname"`
+	Created  time.Time `json:"created"`
+}
+
+type apiEvent struct {
+	ID        int             `json:"id"`
+	Timestamp time.Time       `json:"timestamp"`
+	Duration  float64         `json:"duration"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func (a *API) Buckets(ctx context.Context, since time.Time) (Iterator[data.Bucket], error) {
+	var response map[string]apiBucket
+	err := a.get(ctx, "/api/0/buckets/", &response)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make([]data.Bucket, 0, len(response))
+	for _, b := range response {
+		if b.Created.Before(since) {
+			continue
+		}
+
+		name := b.Name
+		if name == "" {
+			name = b.ID
+		}
+
+		buckets = append(buckets, data.Bucket{
+			DeviceID: a.deviceID,
+			Key:      bucketKey(b.ID),
+			ID:       b.ID,
+			Created:  b.Created.UTC(),
+			Name:     name,
+			Type:     b.Type,
+			Client:   b.Client,
+			Hostname: b.Hostname,
+		})
+	}
+
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Key < buckets[j].Key })
+
+	return &sliceIter[data.Bucket]{values: buckets}, nil
+}
+
+func (a *API) Events(ctx context.Context, filter EventFilter) (Iterator[data.Event], error) {
+	it := &apiEvents{
+		api:    a,
+		ctx:    ctx,
+		filter: filter,
+		until:  time.Now().UTC(),
+	}
+	if filter.Since.IsZero() {
+		// the first window reads the events from before the bucket was created, e.g. imported ones
+		it.end = filter.Bucket.Created
+	} else {
+		it.start = filter.Since
+		it.end = it.nextEnd()
+	}
+	return it, nil
+}
+
+// apiEvents reads the events of a bucket one time window at a time, oldest window first.
+// aw-server-rust trims events to the window, so events reaching its end are held back
+// and joined with their rest from the next window.
+type apiEvents struct {
+	api    *API
+	ctx    context.Context
+	filter EventFilter
+	until  time.Time // the window reaching past this is read without an end
+
+	start   time.Time // of the next window, zero for no start
+	end     time.Time // of the next window, zero for the last one
+	done    bool
+	page    []data.Event
+	carried map[int]data.Event
+	current data.Event
+	err     error
+}
+
+func (it *apiEvents) nextEnd() time.Time {
+	end := it.start.Add(it.api.PageSpan)
+	if end.After(it.until) {
+		return time.Time{}
+	}
+	return end
+}
+
+func (it *apiEvents) Next() bool {
+	for len(it.page) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		it.err = it.fetch()
+	}
+
+	it.current = it.page[0]
+	it.page = it.page[1:]
+	return true
+}
+
+// fetch reads the next window into page
+func (it *apiEvents) fetch() error {
+	query := url.Values{}
+	query.Set("limit", "-1")
+	if !it.start.IsZero() {
+		query.Set("start", it.start.Format(time.RFC3339Nano))
+	}
+	if !it.end.IsZero() {
+		query.Set("end", it.end.Format(time.RFC3339Nano))
+	}
+
+	var response []apiEvent
+	err := it.api.get(it.ctx, "/api/0/buckets/"+url.PathEscape(it.filter.Bucket.ID)+"/events?"+query.Encode(), &response)
+	if err != nil {
+		return err
+	}
+
+	carried := make(map[int]data.Event)
+	for _, e := range response {
+		// start is a time filter, the id watermark still decides what is new
+		if e.ID < it.filter.FromID {
+			continue
+		}
+
+		event := data.Event{
+			DeviceID:  it.api.deviceID,
+			ID:        e.ID,
+			BucketID:  it.filter.Bucket.Key,
+			Timestamp: e.Timestamp.UTC(),
+			Duration:  e.Duration,
+			DataStr:   string(e.Data),
+		}
+		end := eventEnd(event)
+
+		if before, ok := it.carried[e.ID]; ok {
+			event.Timestamp = before.Timestamp
+			event.Duration = end.Sub(before.Timestamp).Seconds()
+			delete(it.carried, e.ID)
+		}
+		if !it.end.IsZero() && !end.Before(it.end) {
+			carried[e.ID] = event
+			continue
+		}
+		it.page = append(it.page, event)
+	}
+	// events that ended right at the end of the last window
+	for _, event := range it.carried {
+		it.page = append(it.page, event)
+	}
+	it.carried = carried
+
+	// the API returns the newest events first
+	sort.Slice(it.page, func(i, j int) bool { return it.page[i].ID < it.page[j].ID })
+
+	if it.end.IsZero() {
+		it.done = true
+		return nil
+	}
+	it.start = it.end
+	it.end = it.nextEnd()
+	return nil
+}
+
+func eventEnd(event data.Event) time.Time {
+	return event.Timestamp.Add(time.Duration(event.Duration * float64(time.Second)))
+}
+
+func (it *apiEvents) Value() data.Event {
+	return it.current
+}
+
+func (it *apiEvents) Err() error {
+	return it.err
+}
+
+func (it *apiEvents) Close() error {
+	return nil
+}
+
+func (a *API) get(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", a.BaseURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("error creating HTTP request: %v", err)
+	}
+
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error making HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP request to %s failed with status: %v", path, resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// bucketKey derives a stable integer key from a bucket id, as the API does not expose the row id
+func bucketKey(id string) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() & 0x7fffffff)
+}
@@ -0,0 +1,199 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/azaurus1/lifevisor/internal/data"
+)
+
+// fakeServer stands in for aw-server-rust: events overlapping start and end are
+// returned newest first, trimmed to the range
+type fakeServer struct {
+	buckets  map[string]apiBucket
+	events   map[string][]apiEvent
+	requests []string
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/0/buckets/")
+	if path == "" {
+		json.NewEncoder(w).Encode(f.buckets)
+		return
+	}
+
+	id, ok := strings.CutSuffix(path, "/events")
+	if _, exists := f.buckets[id]; !ok || !exists || r.URL.Query().Get("limit") != "-1" {
+		http.NotFound(w, r)
+		return
+	}
+	f.requests = append(f.requests, r.URL.RawQuery)
+
+	start, end := parseRange(r.URL.Query().Get("start")), parseRange(r.URL.Query().Get("end"))
+	var events []apiEvent
+	for _, e := range f.events[id] {
+		from := e.Timestamp
+		to := e.Timestamp.Add(time.Duration(e.Duration * float64(time.Second)))
+		if !start.IsZero() && to.Before(start) || !end.IsZero() && from.After(end) {
+			continue
+		}
+		if !start.IsZero() && from.Before(start) {
+			from = start
+		}
+		if !end.IsZero() && to.After(end) {
+			to = end
+		}
+		e.Timestamp = from
+		e.Duration = to.Sub(from).Seconds()
+		events = append(events, e)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Timestamp.After(events[j].Timestamp) })
+
+	json.NewEncoder(w).Encode(events)
+}
+
+func parseRange(value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	t, _ := time.Parse(time.RFC3339Nano, value)
+	return t
+}
+
+func newFakeServer(t *testing.T) (*fakeServer, *API) {
+	t.Helper()
+
+	created := time.Date(2024, 12, 13, 0, 0, 0, 0, time.UTC)
+	fake := &fakeServer{
+		buckets: map[string]apiBucket{
+			"aw-watcher-window_host": {ID: "aw-watcher-window_host", Type: "currentwindow", Client: "aw-watcher-window", Hostname: "host", Created: created},
+			"aw-watcher-afk_host":    {ID: "aw-watcher-afk_host", Name: "afk", Type: "afkstatus", Client: "aw-watcher-afk", Hostname: "host", Created: created.Add(time.Hour)},
+		},
+		events: map[string][]apiEvent{
+			"aw-watcher-window_host": {
+				{ID: 1, Timestamp: created.Add(-time.Hour), Duration: 60, Data: json.RawMessage(`{"app":"Imported"}`)},
+				{ID: 2, Timestamp: created.Add(10 * time.Hour), Duration: 600, Data: json.RawMessage(`{"app":"Code"}`)},
+				// spans the end of the first and the whole second day
+				{ID: 3, Timestamp: created.Add(23 * time.Hour), Duration: (26 * time.Hour).Seconds(), Data: json.RawMessage(`{"app":"Firefox"}`)},
+				{ID: 4, Timestamp: created.Add(50 * time.Hour), Duration: 30, Data: json.RawMessage(`{"app":"Code"}`)},
+			},
+		},
+	}
+
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	return fake, NewAPI(server.URL, "laptop")
+}
+
+func TestAPIBuckets(t *testing.T) {
+	_, api := newFakeServer(t)
+
+	it, err := api.Buckets(context.Background(), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buckets, err := Collect(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 2 {
+		t.Fatalf("got %d buckets, want 2", len(buckets))
+	}
+
+	names := map[string]string{}
+	for _, bucket := range buckets {
+		if bucket.DeviceID != "laptop" || bucket.Key != bucketKey(bucket.ID) {
+			t.Errorf("bucket %+v has the wrong device or key", bucket)
+		}
+		names[bucket.ID] = bucket.Name
+	}
+	// a bucket without a name is named after its id
+	if names["aw-watcher-window_host"] != "aw-watcher-window_host" || names["aw-watcher-afk_host"] != "afk" {
+		t.Errorf("names = %v", names)
+	}
+}
+
+func TestAPIEventsPaged(t *testing.T) {
+	fake, api := newFakeServer(t)
+	bucket := data.Bucket{
+		Key:     bucketKey("aw-watcher-window_host"),
+		ID:      "aw-watcher-window_host",
+		Created: time.Date(2024, 12, 13, 0, 0, 0, 0, time.UTC),
+	}
+
+	it, err := api.Events(context.Background(), From(bucket, data.SyncState{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := Collect(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the event crossing two windows comes back whole
+	want := map[int]float64{1: 60, 2: 600, 3: (26 * time.Hour).Seconds(), 4: 30}
+	if len(events) != len(want) {
+		t.Fatalf("got %+v, want %d events", events, len(want))
+	}
+	for i, event := range events {
+		if event.ID != i+1 {
+			t.Errorf("event %d has id %d, want them in id order", i, event.ID)
+		}
+		if event.Duration != want[event.ID] {
+			t.Errorf("event %d lasts %vs, want %vs", event.ID, event.Duration, want[event.ID])
+		}
+	}
+	if events[2].Timestamp != time.Date(2024, 12, 13, 23, 0, 0, 0, time.UTC) {
+		t.Errorf("event 3 starts at %v, want its original start", events[2].Timestamp)
+	}
+
+	// before the bucket was created, then one request per day
+	if len(fake.requests) < 4 {
+		t.Errorf("made %d requests, want one per day: %v", len(fake.requests), fake.requests)
+	}
+	for _, query := range fake.requests[:len(fake.requests)-1] {
+		if !strings.Contains(query, "end=") {
+			t.Errorf("request %q reads without an end before the last window", query)
+		}
+	}
+}
+
+func TestAPIEventsFromWatermark(t *testing.T) {
+	_, api := newFakeServer(t)
+	bucket := data.Bucket{Key: 1, ID: "aw-watcher-window_host"}
+	state := data.SyncState{LastEventID: 3, LastTimestamp: time.Date(2024, 12, 13, 23, 0, 0, 0, time.UTC)}
+
+	it, err := api.Events(context.Background(), From(bucket, state))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := Collect(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].ID != 3 || events[1].ID != 4 {
+		t.Errorf("got %+v, want events 3 and 4", events)
+	}
+	if events[0].BucketID != 1 || events[0].DeviceID != "laptop" {
+		t.Errorf("event 3 = %+v, want it in bucket 1 of laptop", events[0])
+	}
+}
+
+func TestAPIEventsStatus(t *testing.T) {
+	_, api := newFakeServer(t)
+
+	it, err := api.Events(context.Background(), From(data.Bucket{ID: "missing"}, data.SyncState{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Collect(it); err == nil {
+		t.Error("reading a missing bucket succeeded")
+	}
+}
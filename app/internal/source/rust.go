@@ -0,0 +1,95 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/azaurus1/lifevisor/internal/data"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// RustSQLite reads the sqlite.db file of aw-server-rust, which keeps buckets and events
+// in tables named buckets and events with nanosecond start and end times
+type RustSQLite struct {
+	deviceID string
+	conn     *sqlite.Conn
+}
+
+func (s *RustSQLite) Close() error {
+	return s.conn.Close()
+}
+
+func (s *RustSQLite) Buckets(ctx context.Context, since time.Time) (Iterator[data.Bucket], error) {
+	// the bucket id string lives in the name column, the integer id is the row id events refer to
+	stmt, _, err := s.conn.PrepareTransient("SELECT id, name, type, client, hostname, created FROM buckets ORDER BY id;")
+	if err != nil {
+		return nil, err
+	}
+
+	return &rowIter[data.Bucket]{
+		ctx:  ctx,
+		stmt: stmt,
+		scan: func(stmt *sqlite.Stmt) (data.Bucket, bool, error) {
+			var bucket data.Bucket
+
+			created, err := ParseTime(stmt.ColumnText(5))
+			if err != nil {
+				return bucket, false, fmt.Errorf("bucket %s: %w", stmt.ColumnText(1), err)
+			}
+
+			bucket.DeviceID = s.deviceID
+			bucket.Key = stmt.ColumnInt(0)
+			bucket.ID = stmt.ColumnText(1)
+			bucket.Created = created
+			bucket.Name = stmt.ColumnText(1)
+			bucket.Type = stmt.ColumnText(2)
+			bucket.Client = stmt.ColumnText(3)
+			bucket.Hostname = stmt.ColumnText(4)
+
+			return bucket, !created.Before(since), nil
+		},
+	}, nil
+}
+
+func (s *RustSQLite) Events(ctx context.Context, filter EventFilter) (Iterator[data.Event], error) {
+	stmt, _, err := s.conn.PrepareTransient("SELECT id, bucketrow, starttime, endtime, data FROM events WHERE bucketrow = ? AND id >= ? ORDER BY id;")
+	if err != nil {
+		return nil, err
+	}
+	stmt.BindInt64(1, int64(filter.Bucket.Key))
+	stmt.BindInt64(2, int64(filter.FromID))
+
+	return &rowIter[data.Event]{
+		ctx:  ctx,
+		stmt: stmt,
+		scan: func(stmt *sqlite.Stmt) (data.Event, bool, error) {
+			var event data.Event
+
+			start := stmt.ColumnInt64(2)
+			end := stmt.ColumnInt64(3)
+
+			event.DeviceID = s.deviceID
+			event.ID = stmt.ColumnInt(0)
+			event.BucketID = stmt.ColumnInt(1)
+			event.Timestamp = time.Unix(0, start).UTC()
+			event.Duration = time.Duration(end - start).Seconds()
+			event.DataStr = stmt.ColumnText(4)
+
+			return event, true, nil
+		},
+	}, nil
+}
+
+func (s *RustSQLite) CountEvents(ctx context.Context, filter EventFilter) (int, error) {
+	var count int
+	err := sqlitex.ExecuteTransient(s.conn, "SELECT count(*) FROM events WHERE bucketrow = ? AND id >= ?;", &sqlitex.ExecOptions{
+		Args: []interface{}{filter.Bucket.Key, filter.FromID},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			count = stmt.ColumnInt(0)
+			return nil
+		},
+	})
+	return count, err
+}
@@ -0,0 +1,138 @@
+package source
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/azaurus1/lifevisor/internal/data"
+)
+
+// the schema of aw-server-rust, with start and end times in nanoseconds since the epoch
+const rustFixture = `
+CREATE TABLE buckets (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT UNIQUE NOT NULL,
+	type TEXT NOT NULL,
+	client TEXT NOT NULL,
+	hostname TEXT NOT NULL,
+	created TEXT NOT NULL,
+	data TEXT NOT NULL DEFAULT '{}'
+);
+CREATE TABLE events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	bucketrow INTEGER NOT NULL,
+	starttime INTEGER NOT NULL,
+	endtime INTEGER NOT NULL,
+	data TEXT NOT NULL,
+	FOREIGN KEY (bucketrow) REFERENCES buckets (id)
+);
+INSERT INTO buckets (id, name, type, client, hostname, created) VALUES
+	(1, 'aw-watcher-window_host', 'currentwindow', 'aw-watcher-window', 'host', '2024-12-13T09:00:00.123456789+00:00'),
+	(2, 'aw-watcher-afk_host', 'afkstatus', 'aw-watcher-afk', 'host', '2024-12-13T09:00:00Z');
+INSERT INTO events (id, bucketrow, starttime, endtime, data) VALUES
+	(1, 1, 1734084000000000001, 1734084001500000001, '{"app":"Code"}'),
+	(2, 2, 1734084000000000000, 1734084060000000000, '{"status":"not-afk"}'),
+	(3, 1, 1734084002000000000, 1734084005000000000, '{"app":"Firefox"}');
+`
+
+func openRust(t *testing.T) Source {
+	t.Helper()
+
+	src, err := OpenSQLite(writeFixture(t, "sqlite.db", rustFixture), "desktop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { src.Close() })
+
+	if _, ok := src.(*RustSQLite); !ok {
+		t.Fatalf("OpenSQLite returned %T, want *RustSQLite", src)
+	}
+	return src
+}
+
+func TestRustSQLiteBuckets(t *testing.T) {
+	src := openRust(t)
+
+	it, err := src.Buckets(context.Background(), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buckets, err := Collect(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := data.Bucket{
+		DeviceID: "desktop",
+		Key:      1,
+		ID:       "aw-watcher-window_host",
+		Created:  time.Date(2024, 12, 13, 9, 0, 0, 123456789, time.UTC),
+		Name:     "aw-watcher-window_host",
+		Type:     "currentwindow",
+		Client:   "aw-watcher-window",
+		Hostname: "host",
+	}
+	if len(buckets) != 2 {
+		t.Fatalf("got %d buckets, want 2", len(buckets))
+	}
+	if buckets[0] != want {
+		t.Errorf("bucket 0 = %+v, want %+v", buckets[0], want)
+	}
+	if buckets[1].Key != 2 || buckets[1].Type != "afkstatus" {
+		t.Errorf("bucket 1 = %+v, want the afk bucket", buckets[1])
+	}
+}
+
+func TestRustSQLiteEvents(t *testing.T) {
+	src := openRust(t)
+	bucket := data.Bucket{Key: 1, ID: "aw-watcher-window_host"}
+
+	it, err := src.Events(context.Background(), From(bucket, data.SyncState{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := Collect(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []data.Event{
+		{DeviceID: "desktop", ID: 1, BucketID: 1, Timestamp: time.Date(2024, 12, 13, 10, 0, 0, 1, time.UTC), Duration: 1.5, DataStr: `{"app":"Code"}`},
+		{DeviceID: "desktop", ID: 3, BucketID: 1, Timestamp: time.Date(2024, 12, 13, 10, 0, 2, 0, time.UTC), Duration: 3, DataStr: `{"app":"Firefox"}`},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, events[i], want[i])
+		}
+	}
+}
+
+func TestRustSQLiteEventsFromWatermark(t *testing.T) {
+	src := openRust(t)
+	bucket := data.Bucket{Key: 1, ID: "aw-watcher-window_host"}
+	state := data.SyncState{LastEventID: 2, LastTimestamp: time.Date(2024, 12, 13, 10, 0, 0, 0, time.UTC)}
+
+	it, err := src.Events(context.Background(), From(bucket, state))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := Collect(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].ID != 3 {
+		t.Errorf("got %+v, want event 3", events)
+	}
+
+	count, err := src.(Counter).CountEvents(context.Background(), From(bucket, state))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("CountEvents = %d, want 1", count)
+	}
+}
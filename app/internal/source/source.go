@@ -0,0 +1,125 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/azaurus1/lifevisor/internal/data"
+)
+
+// Source is where ActivityWatch buckets and events are read from.
+// A source reads through one connection, so only one iterator may be open at a time.
+type Source interface {
+	// Buckets iterates over the buckets created at or after since, the zero time reads them all
+	Buckets(ctx context.Context, since time.Time) (Iterator[data.Bucket], error)
+	// Events iterates over the events selected by filter in id order
+	Events(ctx context.Context, filter EventFilter) (Iterator[data.Event], error)
+	Close() error
+}
+
+// Counter is implemented by sources that can tell how many events a filter selects without reading them
+type Counter interface {
+	CountEvents(ctx context.Context, filter EventFilter) (int, error)
+}
+
+// EventFilter selects the events of one bucket from an id on
+type EventFilter struct {
+	Bucket data.Bucket
+	FromID int       // first event id to read, inclusive
+	Since  time.Time // timestamp of the FromID event, for sources that can only filter by time
+}
+
+// From returns the filter reading bucket from its watermark on, the zero SyncState reads everything
+func From(bucket data.Bucket, state data.SyncState) EventFilter {
+	return EventFilter{
+		Bucket: bucket,
+		FromID: state.LastEventID,
+		Since:  state.LastTimestamp,
+	}
+}
+
+// Iterator streams values out of a source
+//
+//	for it.Next() {
+//		value := it.Value()
+//	}
+//	err := it.Err()
+type Iterator[T any] interface {
+	Next() bool
+	Value() T
+	Err() error
+	Close() error
+}
+
+// Collect reads the rest of it into a slice and closes it
+func Collect[T any](it Iterator[T]) ([]T, error) {
+	defer it.Close()
+
+	var values []T
+	for it.Next() {
+		values = append(values, it.Value())
+	}
+
+	return values, it.Err()
+}
+
+// APIScheme selects the ActivityWatch REST API instead of a SQLite file, e.g. aw-api://localhost:5600
+const APIScheme = "aw-api://"
+
+// Open returns the source at path, stamping everything it reads with deviceID
+func Open(path, deviceID string) (Source, error) {
+	if strings.HasPrefix(path, APIScheme) {
+		return NewAPI("http://"+strings.TrimPrefix(path, APIScheme), deviceID), nil
+	}
+	return OpenSQLite(path, deviceID)
+}
+
+// Layouts ActivityWatch has written timestamps in, with or without a T separator and
+// a zone; times without a zone are UTC
+var timeLayouts = []string{
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999-0700",
+	"2006-01-02 15:04:05.999999999-0700",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+}
+
+// ParseTime reads a timestamp in any of the layouts ActivityWatch uses and returns it in UTC
+func ParseTime(value string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		parsed, err := time.Parse(layout, value)
+		if err == nil {
+			return parsed.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised time %q", value)
+}
+
+// sliceIter iterates over values that were already read
+type sliceIter[T any] struct {
+	values []T
+	index  int
+}
+
+func (it *sliceIter[T]) Next() bool {
+	if it.index >= len(it.values) {
+		return false
+	}
+	it.index++
+	return true
+}
+
+func (it *sliceIter[T]) Value() T {
+	return it.values[it.index-1]
+}
+
+func (it *sliceIter[T]) Err() error {
+	return nil
+}
+
+func (it *sliceIter[T]) Close() error {
+	return nil
+}
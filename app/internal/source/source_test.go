@@ -0,0 +1,41 @@
+package source
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"2024-12-13T10:00:00Z", time.Date(2024, 12, 13, 10, 0, 0, 0, time.UTC)},
+		{"2024-12-13T10:00:00.123456+00:00", time.Date(2024, 12, 13, 10, 0, 0, 123456000, time.UTC)},
+		{"2024-12-13 10:00:00.5+00:00", time.Date(2024, 12, 13, 10, 0, 0, 500000000, time.UTC)},
+		{"2024-12-13T12:00:00+02:00", time.Date(2024, 12, 13, 10, 0, 0, 0, time.UTC)},
+		{"2024-12-13T12:00:00.000000001+0200", time.Date(2024, 12, 13, 10, 0, 0, 1, time.UTC)},
+		{"2024-12-13 05:00:00-0500", time.Date(2024, 12, 13, 10, 0, 0, 0, time.UTC)},
+		{"2024-12-13T10:00:00.25", time.Date(2024, 12, 13, 10, 0, 0, 250000000, time.UTC)},
+		{"2024-12-13 10:00:00", time.Date(2024, 12, 13, 10, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		got, err := ParseTime(test.value)
+		if err != nil {
+			t.Errorf("ParseTime(%q): %v", test.value, err)
+			continue
+		}
+		if !got.Equal(test.want) || got.Location() != time.UTC {
+			t.Errorf("ParseTime(%q) = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestParseTimeInvalid(t *testing.T) {
+	for _, value := range []string{"", "yesterday", "13/12/2024 10:00", "2024-12-13"} {
+		if _, err := ParseTime(value); err == nil {
+			t.Errorf("ParseTime(%q) succeeded", value)
+		}
+	}
+}
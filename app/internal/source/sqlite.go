@@ -0,0 +1,177 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/azaurus1/lifevisor/internal/data"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// OpenSQLite opens an ActivityWatch database file, telling from its tables
+// whether aw-server (peewee) or aw-server-rust wrote it
+func OpenSQLite(path, deviceID string) (Source, error) {
+	conn, err := sqlite.OpenConn(path, sqlite.OpenReadOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	tables := make(map[string]bool)
+	err = sqlitex.ExecuteTransient(conn, "SELECT name FROM sqlite_master WHERE type = 'table';", &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			tables[stmt.ColumnText(0)] = true
+			return nil
+		},
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	switch {
+	case tables["bucketmodel"] && tables["eventmodel"]:
+		return &SQLite{deviceID: deviceID, conn: conn}, nil
+	case tables["buckets"] && tables["events"]:
+		return &RustSQLite{deviceID: deviceID, conn: conn}, nil
+	default:
+		conn.Close()
+		return nil, fmt.Errorf("%s is not an ActivityWatch database", path)
+	}
+}
+
+// SQLite reads the peewee-sqlite.v2.db file of aw-server
+type SQLite struct {
+	deviceID string
+	conn     *sqlite.Conn
+}
+
+func (s *SQLite) Close() error {
+	return s.conn.Close()
+}
+
+func (s *SQLite) Buckets(ctx context.Context, since time.Time) (Iterator[data.Bucket], error) {
+	stmt, _, err := s.conn.PrepareTransient("SELECT key, id, created, name, type, client, hostname FROM bucketmodel ORDER BY key;")
+	if err != nil {
+		return nil, err
+	}
+
+	return &rowIter[data.Bucket]{
+		ctx:  ctx,
+		stmt: stmt,
+		scan: func(stmt *sqlite.Stmt) (data.Bucket, bool, error) {
+			var bucket data.Bucket
+
+			created, err := ParseTime(stmt.ColumnText(2))
+			if err != nil {
+				return bucket, false, fmt.Errorf("bucket %s: %w", stmt.ColumnText(1), err)
+			}
+
+			bucket.DeviceID = s.deviceID
+			bucket.Key = stmt.ColumnInt(0)
+			bucket.ID = stmt.ColumnText(1)
+			bucket.Created = created
+			bucket.Name = stmt.ColumnText(3)
+			bucket.Type = stmt.ColumnText(4)
+			bucket.Client = stmt.ColumnText(5)
+			bucket.Hostname = stmt.ColumnText(6)
+
+			return bucket, !created.Before(since), nil
+		},
+	}, nil
+}
+
+func (s *SQLite) Events(ctx context.Context, filter EventFilter) (Iterator[data.Event], error) {
+	stmt, _, err := s.conn.PrepareTransient("SELECT id, bucket_id, timestamp, duration, datastr FROM eventmodel WHERE bucket_id = ? AND id >= ? ORDER BY id;")
+	if err != nil {
+		return nil, err
+	}
+	stmt.BindInt64(1, int64(filter.Bucket.Key))
+	stmt.BindInt64(2, int64(filter.FromID))
+
+	return &rowIter[data.Event]{
+		ctx:  ctx,
+		stmt: stmt,
+		scan: func(stmt *sqlite.Stmt) (data.Event, bool, error) {
+			var event data.Event
+
+			timestamp, err := ParseTime(stmt.ColumnText(2))
+			if err != nil {
+				return event, false, fmt.Errorf("event %d: %w", stmt.ColumnInt(0), err)
+			}
+
+			event.DeviceID = s.deviceID
+			event.ID = stmt.ColumnInt(0)
+			event.BucketID = stmt.ColumnInt(1)
+			event.Timestamp = timestamp
+			event.Duration = stmt.ColumnFloat(3)
+			event.DataStr = stmt.ColumnText(4)
+
+			return event, true, nil
+		},
+	}, nil
+}
+
+// rowIter steps through the rows of a statement, scan returns false for rows to skip
+type rowIter[T any] struct {
+	ctx     context.Context
+	stmt    *sqlite.Stmt
+	scan    func(stmt *sqlite.Stmt) (T, bool, error)
+	current T
+	err     error
+	done    bool
+}
+
+func (it *rowIter[T]) Next() bool {
+	for !it.done && it.err == nil {
+		if it.err = it.ctx.Err(); it.err != nil {
+			return false
+		}
+
+		hasRow, err := it.stmt.Step()
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if !hasRow {
+			it.done = true
+			return false
+		}
+
+		value, keep, err := it.scan(it.stmt)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if keep {
+			it.current = value
+			return true
+		}
+	}
+	return false
+}
+
+func (it *rowIter[T]) Value() T {
+	return it.current
+}
+
+func (it *rowIter[T]) Err() error {
+	return it.err
+}
+
+func (it *rowIter[T]) Close() error {
+	return it.stmt.Finalize()
+}
+
+func (s *SQLite) CountEvents(ctx context.Context, filter EventFilter) (int, error) {
+	var count int
+	err := sqlitex.ExecuteTransient(s.conn, "SELECT count(*) FROM eventmodel WHERE bucket_id = ? AND id >= ?;", &sqlitex.ExecOptions{
+		Args: []interface{}{filter.Bucket.Key, filter.FromID},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			count = stmt.ColumnInt(0)
+			return nil
+		},
+	})
+	return count, err
+}
@@ -0,0 +1,221 @@
+package source
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/azaurus1/lifevisor/internal/data"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// writeFixture creates a SQLite file in a temporary directory from script
+func writeFixture(t *testing.T, name, script string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	conn, err := sqlite.OpenConn(path, sqlite.OpenReadWrite, sqlite.OpenCreate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	err = sqlitex.ExecuteScript(conn, script, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// the columns are out of the order the source selects them in, with one it does not know
+const peeweeFixture = `
+CREATE TABLE bucketmodel (
+	hostname VARCHAR(255) NOT NULL,
+	datastr TEXT NOT NULL DEFAULT '{}',
+	key INTEGER NOT NULL PRIMARY KEY,
+	type VARCHAR(255) NOT NULL,
+	id VARCHAR(255) NOT NULL,
+	client VARCHAR(255) NOT NULL,
+	name VARCHAR(255),
+	created DATETIME NOT NULL
+);
+CREATE TABLE eventmodel (
+	datastr VARCHAR(255) NOT NULL,
+	duration DECIMAL(10, 5) NOT NULL,
+	id INTEGER NOT NULL PRIMARY KEY,
+	timestamp DATETIME NOT NULL,
+	bucket_id INTEGER NOT NULL REFERENCES bucketmodel (key)
+);
+INSERT INTO bucketmodel (key, id, created, name, type, client, hostname) VALUES
+	(1, 'aw-watcher-window_host', '2024-12-13 09:00:00.123456+00:00', 'aw-watcher-window_host', 'currentwindow', 'aw-watcher-window', 'host'),
+	(2, 'aw-watcher-afk_host', '2024-12-14T09:00:00+02:00', 'aw-watcher-afk_host', 'afkstatus', 'aw-watcher-afk', 'host');
+INSERT INTO eventmodel (id, bucket_id, timestamp, duration, datastr) VALUES
+	(1, 1, '2024-12-13 10:00:00.5+00:00', 1.5, '{"app": "Code", "title": "main.go"}'),
+	(2, 2, '2024-12-13 10:00:00+00:00', 60, '{"status": "not-afk"}'),
+	(3, 1, '2024-12-13 10:00:02', 3, '{"app": "Firefox", "title": "Go"}'),
+	(4, 1, '2024-12-13T10:00:05.000001+01:00', 0.25, '{"app": "Code", "title": "sqlite.go"}');
+`
+
+func openPeewee(t *testing.T) Source {
+	t.Helper()
+
+	src, err := OpenSQLite(writeFixture(t, "peewee-sqlite.v2.db", peeweeFixture), "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { src.Close() })
+
+	if _, ok := src.(*SQLite); !ok {
+		t.Fatalf("OpenSQLite returned %T, want *SQLite", src)
+	}
+	return src
+}
+
+func TestSQLiteBuckets(t *testing.T) {
+	src := openPeewee(t)
+
+	it, err := src.Buckets(context.Background(), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buckets, err := Collect(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []data.Bucket{
+		{
+			DeviceID: "laptop",
+			Key:      1,
+			ID:       "aw-watcher-window_host",
+			Created:  time.Date(2024, 12, 13, 9, 0, 0, 123456000, time.UTC),
+			Name:     "aw-watcher-window_host",
+			Type:     "currentwindow",
+			Client:   "aw-watcher-window",
+			Hostname: "host",
+		},
+		{
+			DeviceID: "laptop",
+			Key:      2,
+			ID:       "aw-watcher-afk_host",
+			Created:  time.Date(2024, 12, 14, 7, 0, 0, 0, time.UTC),
+			Name:     "aw-watcher-afk_host",
+			Type:     "afkstatus",
+			Client:   "aw-watcher-afk",
+			Hostname: "host",
+		},
+	}
+	if len(buckets) != len(want) {
+		t.Fatalf("got %d buckets, want %d", len(buckets), len(want))
+	}
+	for i := range want {
+		if buckets[i] != want[i] {
+			t.Errorf("bucket %d = %+v, want %+v", i, buckets[i], want[i])
+		}
+	}
+}
+
+func TestSQLiteBucketsSince(t *testing.T) {
+	src := openPeewee(t)
+
+	it, err := src.Buckets(context.Background(), time.Date(2024, 12, 14, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buckets, err := Collect(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(buckets) != 1 || buckets[0].Key != 2 {
+		t.Errorf("got %+v, want only bucket 2", buckets)
+	}
+}
+
+func TestSQLiteEvents(t *testing.T) {
+	src := openPeewee(t)
+	bucket := data.Bucket{Key: 1, ID: "aw-watcher-window_host"}
+
+	it, err := src.Events(context.Background(), From(bucket, data.SyncState{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := Collect(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []data.Event{
+		{DeviceID: "laptop", ID: 1, BucketID: 1, Timestamp: time.Date(2024, 12, 13, 10, 0, 0, 500000000, time.UTC), Duration: 1.5, DataStr: `{"app": "Code", "title": "main.go"}`},
+		{DeviceID: "laptop", ID: 3, BucketID: 1, Timestamp: time.Date(2024, 12, 13, 10, 0, 2, 0, time.UTC), Duration: 3, DataStr: `{"app": "Firefox", "title": "Go"}`},
+		{DeviceID: "laptop", ID: 4, BucketID: 1, Timestamp: time.Date(2024, 12, 13, 9, 0, 5, 1000, time.UTC), Duration: 0.25, DataStr: `{"app": "Code", "title": "sqlite.go"}`},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, events[i], want[i])
+		}
+	}
+}
+
+func TestSQLiteEventsFromWatermark(t *testing.T) {
+	src := openPeewee(t)
+	bucket := data.Bucket{Key: 1, ID: "aw-watcher-window_host"}
+	state := data.SyncState{LastEventID: 3, LastTimestamp: time.Date(2024, 12, 13, 10, 0, 2, 0, time.UTC)}
+
+	// the watermark event is read again, heartbeats may have extended it
+	it, err := src.Events(context.Background(), From(bucket, state))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := Collect(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].ID != 3 || events[1].ID != 4 {
+		t.Errorf("got %+v, want events 3 and 4", events)
+	}
+
+	count, err := src.(Counter).CountEvents(context.Background(), From(bucket, state))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("CountEvents = %d, want 2", count)
+	}
+}
+
+func TestSQLiteEventsCancelled(t *testing.T) {
+	src := openPeewee(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	it, err := src.Events(ctx, From(data.Bucket{Key: 1}, data.SyncState{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	if !it.Next() {
+		t.Fatalf("no first event: %v", it.Err())
+	}
+	cancel()
+	if it.Next() {
+		t.Error("Next returned an event after the context was cancelled")
+	}
+	if it.Err() != context.Canceled {
+		t.Errorf("Err = %v, want %v", it.Err(), context.Canceled)
+	}
+}
+
+func TestOpenSQLiteUnknown(t *testing.T) {
+	path := writeFixture(t, "other.db", "CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT);")
+
+	_, err := OpenSQLite(path, "laptop")
+	if err == nil {
+		t.Error("OpenSQLite accepted a database without ActivityWatch tables")
+	}
+}
@@ -0,0 +1,12 @@
+-- +migrate Up
+-- Create the sync state table, one high-water mark per source bucket
+CREATE TABLE syncstate (
+    bucket_key INT PRIMARY KEY, -- Foreign key referencing Bucket.Key
+    last_event_id INT NOT NULL, -- Highest eventmodel id synced for the bucket
+    last_timestamp TIMESTAMP NOT NULL, -- Timestamp of that event
+    updated TIMESTAMP NOT NULL DEFAULT NOW (), -- When the watermark last moved
+    FOREIGN KEY (bucket_key) REFERENCES bucketmodel (key) ON DELETE CASCADE -- Cascade delete
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS syncstate;
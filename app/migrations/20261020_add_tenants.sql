@@ -0,0 +1,100 @@
+-- +migrate Up
+-- Tenants are the users sharing one lifevisor-service, every token belongs to one
+-- and every bucket, event and watermark is stored under one
+CREATE TABLE tenant (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    created TIMESTAMP NOT NULL DEFAULT NOW ()
+);
+
+-- Rows that existed before tenants, and everything written directly to PostgreSQL, belong to tenant 1
+INSERT INTO tenant (id, name) VALUES (1, 'default');
+SELECT setval(pg_get_serial_sequence('tenant', 'id'), 1);
+
+-- The tenant of the current transaction or session, set by the writer with
+-- set_config('lifevisor.tenant_id', ...), NULL when nothing is set
+-- +migrate StatementBegin
+CREATE FUNCTION lifevisor_tenant () RETURNS INT LANGUAGE sql STABLE AS $$
+    SELECT NULLIF(current_setting('lifevisor.tenant_id', true), '')::INT
+$$;
+-- +migrate StatementEnd
+
+ALTER TABLE apitoken ADD COLUMN tenant_id INT NOT NULL DEFAULT 1 REFERENCES tenant (id) ON DELETE CASCADE;
+ALTER TABLE apitoken ALTER COLUMN tenant_id DROP DEFAULT;
+
+ALTER TABLE syncstate DROP CONSTRAINT syncstate_device_id_bucket_key_fkey;
+ALTER TABLE eventmodel DROP CONSTRAINT eventmodel_device_id_bucket_id_fkey;
+
+ALTER TABLE bucketmodel ADD COLUMN tenant_id INT NOT NULL DEFAULT 1 REFERENCES tenant (id) ON DELETE CASCADE;
+ALTER TABLE eventmodel ADD COLUMN tenant_id INT NOT NULL DEFAULT 1;
+ALTER TABLE syncstate ADD COLUMN tenant_id INT NOT NULL DEFAULT 1;
+
+-- New rows go to the tenant the writer has set, inserts fail when none is set
+ALTER TABLE bucketmodel ALTER COLUMN tenant_id SET DEFAULT lifevisor_tenant ();
+ALTER TABLE eventmodel ALTER COLUMN tenant_id SET DEFAULT lifevisor_tenant ();
+ALTER TABLE syncstate ALTER COLUMN tenant_id SET DEFAULT lifevisor_tenant ();
+
+ALTER TABLE bucketmodel DROP CONSTRAINT bucketmodel_pkey;
+ALTER TABLE bucketmodel DROP CONSTRAINT bucketmodel_device_id_id_key;
+ALTER TABLE bucketmodel ADD PRIMARY KEY (tenant_id, device_id, key);
+ALTER TABLE bucketmodel ADD UNIQUE (tenant_id, device_id, id);
+
+ALTER TABLE eventmodel DROP CONSTRAINT eventmodel_pkey;
+ALTER TABLE eventmodel ADD PRIMARY KEY (tenant_id, device_id, id);
+ALTER TABLE eventmodel ADD FOREIGN KEY (tenant_id, device_id, bucket_id) REFERENCES bucketmodel (tenant_id, device_id, key) ON DELETE CASCADE;
+
+ALTER TABLE syncstate DROP CONSTRAINT syncstate_pkey;
+ALTER TABLE syncstate ADD PRIMARY KEY (tenant_id, device_id, bucket_key);
+ALTER TABLE syncstate ADD FOREIGN KEY (tenant_id, device_id, bucket_key) REFERENCES bucketmodel (tenant_id, device_id, key) ON DELETE CASCADE;
+
+-- Defence in depth, queries filter by tenant themselves. Forced so the owner the service
+-- connects as is subject to them too, superusers still bypass row-level security.
+ALTER TABLE bucketmodel ENABLE ROW LEVEL SECURITY;
+ALTER TABLE bucketmodel FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON bucketmodel USING (tenant_id = lifevisor_tenant ()) WITH CHECK (tenant_id = lifevisor_tenant ());
+
+ALTER TABLE eventmodel ENABLE ROW LEVEL SECURITY;
+ALTER TABLE eventmodel FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON eventmodel USING (tenant_id = lifevisor_tenant ()) WITH CHECK (tenant_id = lifevisor_tenant ());
+
+ALTER TABLE syncstate ENABLE ROW LEVEL SECURITY;
+ALTER TABLE syncstate FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON syncstate USING (tenant_id = lifevisor_tenant ()) WITH CHECK (tenant_id = lifevisor_tenant ());
+
+-- +migrate Down
+DROP POLICY tenant_isolation ON syncstate;
+ALTER TABLE syncstate NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE syncstate DISABLE ROW LEVEL SECURITY;
+DROP POLICY tenant_isolation ON eventmodel;
+ALTER TABLE eventmodel NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE eventmodel DISABLE ROW LEVEL SECURITY;
+DROP POLICY tenant_isolation ON bucketmodel;
+ALTER TABLE bucketmodel NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE bucketmodel DISABLE ROW LEVEL SECURITY;
+
+-- Only the default tenant fits the keys without tenants
+DELETE FROM bucketmodel WHERE tenant_id <> 1;
+
+ALTER TABLE syncstate DROP CONSTRAINT syncstate_tenant_id_device_id_bucket_key_fkey;
+ALTER TABLE eventmodel DROP CONSTRAINT eventmodel_tenant_id_device_id_bucket_id_fkey;
+
+ALTER TABLE syncstate DROP CONSTRAINT syncstate_pkey;
+ALTER TABLE syncstate ADD PRIMARY KEY (device_id, bucket_key);
+ALTER TABLE syncstate DROP COLUMN tenant_id;
+
+ALTER TABLE eventmodel DROP CONSTRAINT eventmodel_pkey;
+ALTER TABLE eventmodel ADD PRIMARY KEY (device_id, id);
+ALTER TABLE eventmodel DROP COLUMN tenant_id;
+
+ALTER TABLE bucketmodel DROP CONSTRAINT bucketmodel_tenant_id_device_id_id_key;
+ALTER TABLE bucketmodel DROP CONSTRAINT bucketmodel_pkey;
+ALTER TABLE bucketmodel ADD PRIMARY KEY (device_id, key);
+ALTER TABLE bucketmodel ADD UNIQUE (device_id, id);
+ALTER TABLE bucketmodel DROP COLUMN tenant_id;
+
+ALTER TABLE eventmodel ADD FOREIGN KEY (device_id, bucket_id) REFERENCES bucketmodel (device_id, key) ON DELETE CASCADE;
+ALTER TABLE syncstate ADD FOREIGN KEY (device_id, bucket_key) REFERENCES bucketmodel (device_id, key) ON DELETE CASCADE;
+
+ALTER TABLE apitoken DROP COLUMN tenant_id;
+DROP FUNCTION lifevisor_tenant ();
+DROP TABLE IF EXISTS tenant;
@@ -0,0 +1,137 @@
+-- +migrate Up
+-- The parts of window and browser tab events that overlap a not-afk period of the
+-- aw-watcher-afk bucket on the same device and host, what ActivityWatch shows as active time
+CREATE TABLE activeevent (
+    tenant_id INT NOT NULL DEFAULT lifevisor_tenant (),
+    device_id TEXT NOT NULL,
+    event_id INT NOT NULL, -- The window or tab event, its datastr says what was active
+    timestamp TIMESTAMP NOT NULL, -- Start of the overlap
+    duration FLOAT NOT NULL, -- Length of the overlap in seconds
+    PRIMARY KEY (tenant_id, device_id, event_id, timestamp),
+    FOREIGN KEY (tenant_id, device_id, event_id) REFERENCES eventmodel (tenant_id, device_id, id) ON DELETE CASCADE
+);
+
+CREATE INDEX activeevent_end_idx ON activeevent (tenant_id, (timestamp + duration * INTERVAL '1 second'));
+
+-- Finding the events ending after a point in time, and the afk events overlapping a window event
+CREATE INDEX eventmodel_end_idx ON eventmodel (tenant_id, (timestamp + duration * INTERVAL '1 second'));
+CREATE INDEX eventmodel_bucket_timestamp_idx ON eventmodel (tenant_id, device_id, bucket_id, timestamp);
+
+-- The earliest event timestamp written since activeevent was last refreshed, per tenant
+CREATE TABLE activeevent_dirty (
+    tenant_id INT PRIMARY KEY DEFAULT lifevisor_tenant () REFERENCES tenant (id) ON DELETE CASCADE,
+    since TIMESTAMP NOT NULL
+);
+
+-- Everything synced so far needs computing
+INSERT INTO activeevent_dirty (tenant_id, since)
+SELECT id, '-infinity' FROM tenant;
+
+ALTER TABLE activeevent ENABLE ROW LEVEL SECURITY;
+ALTER TABLE activeevent FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON activeevent USING (tenant_id = lifevisor_tenant ()) WITH CHECK (tenant_id = lifevisor_tenant ());
+
+ALTER TABLE activeevent_dirty ENABLE ROW LEVEL SECURITY;
+ALTER TABLE activeevent_dirty FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON activeevent_dirty USING (tenant_id = lifevisor_tenant ()) WITH CHECK (tenant_id = lifevisor_tenant ());
+
+-- Every write to eventmodel marks activeevent stale from the earliest event it touched
+-- +migrate StatementBegin
+CREATE FUNCTION mark_activeevent_dirty () RETURNS TRIGGER LANGUAGE plpgsql AS $$
+BEGIN
+    INSERT INTO activeevent_dirty (tenant_id, since)
+    SELECT tenant_id, MIN(timestamp) FROM changed GROUP BY tenant_id
+    ON CONFLICT (tenant_id) DO UPDATE SET since = LEAST(activeevent_dirty.since, excluded.since);
+    RETURN NULL;
+END
+$$;
+-- +migrate StatementEnd
+
+CREATE TRIGGER eventmodel_inserted_dirty AFTER INSERT ON eventmodel
+REFERENCING NEW TABLE AS changed FOR EACH STATEMENT EXECUTE FUNCTION mark_activeevent_dirty ();
+
+CREATE TRIGGER eventmodel_updated_dirty AFTER UPDATE ON eventmodel
+REFERENCING NEW TABLE AS changed FOR EACH STATEMENT EXECUTE FUNCTION mark_activeevent_dirty ();
+
+-- Recomputes the active parts of every event ending after the stale mark of the current
+-- tenant and clears the mark, returns how many active parts were written
+-- +migrate StatementBegin
+CREATE FUNCTION refresh_active_events () RETURNS INT LANGUAGE plpgsql AS $$
+DECLARE
+    from_ts TIMESTAMP;
+    window_from TIMESTAMP;
+    written INT;
+BEGIN
+    -- locking the mark makes concurrent writers wait and mark again after us
+    SELECT since INTO from_ts FROM activeevent_dirty WHERE tenant_id = lifevisor_tenant () FOR UPDATE;
+    IF from_ts IS NULL THEN
+        RETURN 0;
+    END IF;
+
+    -- window events ending after the mark are recomputed whole, from their start
+    SELECT LEAST(from_ts, MIN(w.timestamp)) INTO window_from
+    FROM eventmodel w
+    JOIN bucketmodel wb ON wb.tenant_id = w.tenant_id AND wb.device_id = w.device_id AND wb.key = w.bucket_id
+    WHERE w.tenant_id = lifevisor_tenant ()
+        AND wb.type IN ('currentwindow', 'web.tab.current')
+        AND w.timestamp + w.duration * INTERVAL '1 second' > from_ts;
+
+    DELETE FROM activeevent a
+    USING eventmodel w
+    WHERE a.tenant_id = lifevisor_tenant ()
+        AND w.tenant_id = a.tenant_id AND w.device_id = a.device_id AND w.id = a.event_id
+        AND w.timestamp + w.duration * INTERVAL '1 second' > from_ts;
+
+    WITH afk AS (
+        SELECT a.device_id, ab.hostname, a.timestamp AS start_at, a.timestamp + a.duration * INTERVAL '1 second' AS end_at
+        FROM eventmodel a
+        JOIN bucketmodel ab ON ab.tenant_id = a.tenant_id AND ab.device_id = a.device_id AND ab.key = a.bucket_id
+        WHERE a.tenant_id = lifevisor_tenant ()
+            AND ab.type = 'afkstatus'
+            AND a.datastr->>'status' = 'not-afk'
+            AND a.timestamp + a.duration * INTERVAL '1 second' > window_from
+    ), starts AS (
+        -- a not-afk event starting after every earlier one of its host has ended starts a new period
+        SELECT afk.*,
+            CASE WHEN start_at <= MAX(end_at) OVER (PARTITION BY device_id, hostname ORDER BY start_at, end_at
+                ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING) THEN 0 ELSE 1 END AS new_period
+        FROM afk
+    ), numbered AS (
+        SELECT starts.*,
+            SUM(new_period) OVER (PARTITION BY device_id, hostname ORDER BY start_at, end_at ROWS UNBOUNDED PRECEDING) AS period
+        FROM starts
+    ), periods AS (
+        -- overlapping not-afk events merged, so their time is counted once
+        SELECT device_id, hostname, MIN(start_at) AS start_at, MAX(end_at) AS end_at
+        FROM numbered
+        GROUP BY device_id, hostname, period
+    )
+    INSERT INTO activeevent (tenant_id, device_id, event_id, timestamp, duration)
+    SELECT w.tenant_id, w.device_id, w.id,
+        GREATEST(w.timestamp, p.start_at),
+        EXTRACT(EPOCH FROM LEAST(w.timestamp + w.duration * INTERVAL '1 second', p.end_at) - GREATEST(w.timestamp, p.start_at))
+    FROM eventmodel w
+    JOIN bucketmodel wb ON wb.tenant_id = w.tenant_id AND wb.device_id = w.device_id AND wb.key = w.bucket_id
+    JOIN periods p ON p.device_id = wb.device_id AND p.hostname = wb.hostname
+        AND p.start_at < w.timestamp + w.duration * INTERVAL '1 second'
+        AND p.end_at > w.timestamp
+    WHERE w.tenant_id = lifevisor_tenant ()
+        AND wb.type IN ('currentwindow', 'web.tab.current')
+        AND w.timestamp + w.duration * INTERVAL '1 second' > from_ts;
+    GET DIAGNOSTICS written = ROW_COUNT;
+
+    DELETE FROM activeevent_dirty WHERE tenant_id = lifevisor_tenant ();
+    RETURN written;
+END
+$$;
+-- +migrate StatementEnd
+
+-- +migrate Down
+DROP FUNCTION IF EXISTS refresh_active_events ();
+DROP TRIGGER IF EXISTS eventmodel_updated_dirty ON eventmodel;
+DROP TRIGGER IF EXISTS eventmodel_inserted_dirty ON eventmodel;
+DROP FUNCTION IF EXISTS mark_activeevent_dirty ();
+DROP TABLE IF EXISTS activeevent_dirty;
+DROP TABLE IF EXISTS activeevent;
+DROP INDEX IF EXISTS eventmodel_bucket_timestamp_idx;
+DROP INDEX IF EXISTS eventmodel_end_idx;
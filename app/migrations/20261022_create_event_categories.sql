@@ -0,0 +1,157 @@
+-- +migrate Up
+-- Categorisation rules, in the order they were defined. Like in ActivityWatch an event gets the
+-- deepest category (most ' > ' separated levels) whose regex matches its app, title or url.
+CREATE TABLE category_rule (
+    tenant_id INT NOT NULL DEFAULT lifevisor_tenant () REFERENCES tenant (id) ON DELETE CASCADE,
+    position INT NOT NULL,
+    category TEXT NOT NULL, -- e.g. 'Work > Programming'
+    regex TEXT NOT NULL, -- PostgreSQL regular expression
+    ignore_case BOOLEAN NOT NULL DEFAULT FALSE,
+    PRIMARY KEY (tenant_id, position)
+);
+
+-- The category of every window and browser tab event
+CREATE TABLE event_category (
+    tenant_id INT NOT NULL DEFAULT lifevisor_tenant (),
+    device_id TEXT NOT NULL,
+    event_id INT NOT NULL,
+    category TEXT NOT NULL, -- 'Uncategorized' when no rule matches
+    PRIMARY KEY (tenant_id, device_id, event_id),
+    FOREIGN KEY (tenant_id, device_id, event_id) REFERENCES eventmodel (tenant_id, device_id, id) ON DELETE CASCADE
+);
+
+CREATE INDEX event_category_category_idx ON event_category (tenant_id, category);
+CREATE INDEX eventmodel_timestamp_idx ON eventmodel (tenant_id, timestamp);
+
+-- The earliest event timestamp written since event_category was last refreshed, per tenant
+CREATE TABLE event_category_dirty (
+    tenant_id INT PRIMARY KEY DEFAULT lifevisor_tenant () REFERENCES tenant (id) ON DELETE CASCADE,
+    since TIMESTAMP NOT NULL
+);
+
+-- Everything synced so far needs categorising
+INSERT INTO event_category_dirty (tenant_id, since)
+SELECT id, '-infinity' FROM tenant;
+
+ALTER TABLE category_rule ENABLE ROW LEVEL SECURITY;
+ALTER TABLE category_rule FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON category_rule USING (tenant_id = lifevisor_tenant ()) WITH CHECK (tenant_id = lifevisor_tenant ());
+
+ALTER TABLE event_category ENABLE ROW LEVEL SECURITY;
+ALTER TABLE event_category FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON event_category USING (tenant_id = lifevisor_tenant ()) WITH CHECK (tenant_id = lifevisor_tenant ());
+
+ALTER TABLE event_category_dirty ENABLE ROW LEVEL SECURITY;
+ALTER TABLE event_category_dirty FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON event_category_dirty USING (tenant_id = lifevisor_tenant ()) WITH CHECK (tenant_id = lifevisor_tenant ());
+
+-- Every write to eventmodel marks event_category stale from the earliest event it touched
+-- +migrate StatementBegin
+CREATE FUNCTION mark_event_category_dirty () RETURNS TRIGGER LANGUAGE plpgsql AS $$
+BEGIN
+    INSERT INTO event_category_dirty (tenant_id, since)
+    SELECT tenant_id, MIN(timestamp) FROM changed GROUP BY tenant_id
+    ON CONFLICT (tenant_id) DO UPDATE SET since = LEAST(event_category_dirty.since, excluded.since);
+    RETURN NULL;
+END
+$$;
+-- +migrate StatementEnd
+
+CREATE TRIGGER eventmodel_inserted_category_dirty AFTER INSERT ON eventmodel
+REFERENCING NEW TABLE AS changed FOR EACH STATEMENT EXECUTE FUNCTION mark_event_category_dirty ();
+
+CREATE TRIGGER eventmodel_updated_category_dirty AFTER UPDATE ON eventmodel
+REFERENCING NEW TABLE AS changed FOR EACH STATEMENT EXECUTE FUNCTION mark_event_category_dirty ();
+
+-- Replaces the rules of the current tenant with rules, a JSON array of
+-- {"Category", "Regex", "IgnoreCase"} objects. Returns false without touching anything when the
+-- rules are unchanged, otherwise every event is categorised again on the next refresh.
+-- +migrate StatementBegin
+CREATE FUNCTION set_category_rules (rules JSONB) RETURNS BOOLEAN LANGUAGE plpgsql AS $$
+DECLARE
+    current_rules JSONB;
+BEGIN
+    SELECT COALESCE(jsonb_agg(jsonb_build_object('Category', category, 'Regex', regex, 'IgnoreCase', ignore_case) ORDER BY position), '[]')
+    INTO current_rules FROM category_rule WHERE tenant_id = lifevisor_tenant ();
+    IF current_rules = rules THEN
+        RETURN FALSE;
+    END IF;
+
+    -- fails on an invalid regex before anything is replaced
+    PERFORM '' ~ (rule->>'Regex') FROM jsonb_array_elements(rules) AS r (rule);
+
+    DELETE FROM category_rule WHERE tenant_id = lifevisor_tenant ();
+    INSERT INTO category_rule (position, category, regex, ignore_case)
+    SELECT ordinality, rule->>'Category', rule->>'Regex', COALESCE((rule->>'IgnoreCase')::BOOLEAN, FALSE)
+    FROM jsonb_array_elements(rules) WITH ORDINALITY AS r (rule, ordinality);
+
+    INSERT INTO event_category_dirty (since) VALUES ('-infinity')
+    ON CONFLICT (tenant_id) DO UPDATE SET since = excluded.since;
+    RETURN TRUE;
+END
+$$;
+-- +migrate StatementEnd
+
+-- Categorises the window and tab events starting at or after the stale mark of the current
+-- tenant and clears the mark, returns how many events got a new category
+-- +migrate StatementBegin
+CREATE FUNCTION refresh_event_categories () RETURNS INT LANGUAGE plpgsql AS $$
+DECLARE
+    from_ts TIMESTAMP;
+    written INT;
+BEGIN
+    -- locking the mark makes concurrent writers wait and mark again after us
+    SELECT since INTO from_ts FROM event_category_dirty WHERE tenant_id = lifevisor_tenant () FOR UPDATE;
+    IF from_ts IS NULL THEN
+        RETURN 0;
+    END IF;
+
+    INSERT INTO event_category (tenant_id, device_id, event_id, category)
+    SELECT e.tenant_id, e.device_id, e.id, COALESCE(m.category, 'Uncategorized')
+    FROM eventmodel e
+    JOIN bucketmodel b ON b.tenant_id = e.tenant_id AND b.device_id = e.device_id AND b.key = e.bucket_id
+    LEFT JOIN LATERAL (
+        SELECT r.category FROM category_rule r
+        WHERE r.tenant_id = e.tenant_id AND (
+            CASE WHEN r.ignore_case
+            THEN e.datastr->>'app' ~* r.regex OR e.datastr->>'title' ~* r.regex OR e.datastr->>'url' ~* r.regex
+            ELSE e.datastr->>'app' ~ r.regex OR e.datastr->>'title' ~ r.regex OR e.datastr->>'url' ~ r.regex
+            END)
+        ORDER BY array_length(string_to_array(r.category, ' > '), 1) DESC, r.position
+        LIMIT 1
+    ) m ON TRUE
+    WHERE e.tenant_id = lifevisor_tenant ()
+        AND b.type IN ('currentwindow', 'web.tab.current')
+        AND e.timestamp >= from_ts
+    ON CONFLICT (tenant_id, device_id, event_id) DO UPDATE SET category = excluded.category
+    WHERE event_category.category IS DISTINCT FROM excluded.category;
+    GET DIAGNOSTICS written = ROW_COUNT;
+
+    DELETE FROM event_category_dirty WHERE tenant_id = lifevisor_tenant ();
+    RETURN written;
+END
+$$;
+-- +migrate StatementEnd
+
+-- Categorises every event of the current tenant again
+-- +migrate StatementBegin
+CREATE FUNCTION recategorize_events () RETURNS INT LANGUAGE plpgsql AS $$
+BEGIN
+    INSERT INTO event_category_dirty (since) VALUES ('-infinity')
+    ON CONFLICT (tenant_id) DO UPDATE SET since = excluded.since;
+    RETURN refresh_event_categories ();
+END
+$$;
+-- +migrate StatementEnd
+
+-- +migrate Down
+DROP FUNCTION IF EXISTS recategorize_events ();
+DROP FUNCTION IF EXISTS refresh_event_categories ();
+DROP FUNCTION IF EXISTS set_category_rules (JSONB);
+DROP TRIGGER IF EXISTS eventmodel_updated_category_dirty ON eventmodel;
+DROP TRIGGER IF EXISTS eventmodel_inserted_category_dirty ON eventmodel;
+DROP FUNCTION IF EXISTS mark_event_category_dirty ();
+DROP TABLE IF EXISTS event_category_dirty;
+DROP INDEX IF EXISTS eventmodel_timestamp_idx;
+DROP TABLE IF EXISTS event_category;
+DROP TABLE IF EXISTS category_rule;
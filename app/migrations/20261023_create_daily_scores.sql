@@ -0,0 +1,267 @@
+-- +migrate Up
+-- How productive each category is, from -1 (distracting) to 1 (productive). A category without
+-- a weight takes the one of its closest ancestor, 'Work' covers 'Work > Programming'.
+CREATE TABLE category_score (
+    tenant_id INT NOT NULL DEFAULT lifevisor_tenant () REFERENCES tenant (id) ON DELETE CASCADE,
+    category TEXT NOT NULL,
+    weight FLOAT NOT NULL CHECK (weight BETWEEN -1 AND 1),
+    PRIMARY KEY (tenant_id, category)
+);
+
+-- The time zone days of daily_scores start at midnight in, per tenant
+CREATE TABLE score_setting (
+    tenant_id INT PRIMARY KEY DEFAULT lifevisor_tenant () REFERENCES tenant (id) ON DELETE CASCADE,
+    timezone TEXT NOT NULL DEFAULT 'UTC'
+);
+
+-- Active window time per day. The score runs from 0 (all distracting) over 50 (neutral)
+-- to 100 (all productive) and is the weighted average of the weights of the time spent.
+CREATE TABLE daily_scores (
+    tenant_id INT NOT NULL DEFAULT lifevisor_tenant () REFERENCES tenant (id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    active_seconds FLOAT NOT NULL,
+    productive_seconds FLOAT NOT NULL, -- in categories weighted above 0
+    distracting_seconds FLOAT NOT NULL, -- in categories weighted below 0
+    weighted_seconds FLOAT NOT NULL, -- sum of seconds times weight
+    score FLOAT NOT NULL,
+    PRIMARY KEY (tenant_id, day)
+);
+
+-- The earliest activity changed since daily_scores was last refreshed, per tenant
+CREATE TABLE daily_scores_dirty (
+    tenant_id INT PRIMARY KEY DEFAULT lifevisor_tenant () REFERENCES tenant (id) ON DELETE CASCADE,
+    since TIMESTAMP NOT NULL
+);
+
+INSERT INTO daily_scores_dirty (tenant_id, since)
+SELECT id, '-infinity' FROM tenant;
+
+ALTER TABLE category_score ENABLE ROW LEVEL SECURITY;
+ALTER TABLE category_score FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON category_score USING (tenant_id = lifevisor_tenant ()) WITH CHECK (tenant_id = lifevisor_tenant ());
+
+ALTER TABLE score_setting ENABLE ROW LEVEL SECURITY;
+ALTER TABLE score_setting FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON score_setting USING (tenant_id = lifevisor_tenant ()) WITH CHECK (tenant_id = lifevisor_tenant ());
+
+ALTER TABLE daily_scores ENABLE ROW LEVEL SECURITY;
+ALTER TABLE daily_scores FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON daily_scores USING (tenant_id = lifevisor_tenant ()) WITH CHECK (tenant_id = lifevisor_tenant ());
+
+ALTER TABLE daily_scores_dirty ENABLE ROW LEVEL SECURITY;
+ALTER TABLE daily_scores_dirty FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON daily_scores_dirty USING (tenant_id = lifevisor_tenant ()) WITH CHECK (tenant_id = lifevisor_tenant ());
+
+-- Refreshing active time or categories marks daily_scores stale from the earliest activity touched
+-- +migrate StatementBegin
+CREATE FUNCTION mark_daily_scores_dirty () RETURNS TRIGGER LANGUAGE plpgsql AS $$
+BEGIN
+    INSERT INTO daily_scores_dirty (tenant_id, since)
+    SELECT tenant_id, MIN(timestamp) FROM changed GROUP BY tenant_id
+    ON CONFLICT (tenant_id) DO UPDATE SET since = LEAST(daily_scores_dirty.since, excluded.since);
+    RETURN NULL;
+END
+$$;
+-- +migrate StatementEnd
+
+-- +migrate StatementBegin
+CREATE FUNCTION mark_daily_scores_dirty_by_category () RETURNS TRIGGER LANGUAGE plpgsql AS $$
+BEGIN
+    INSERT INTO daily_scores_dirty (tenant_id, since)
+    SELECT e.tenant_id, MIN(e.timestamp) FROM changed c
+    JOIN eventmodel e ON e.tenant_id = c.tenant_id AND e.device_id = c.device_id AND e.id = c.event_id
+    GROUP BY e.tenant_id
+    ON CONFLICT (tenant_id) DO UPDATE SET since = LEAST(daily_scores_dirty.since, excluded.since);
+    RETURN NULL;
+END
+$$;
+-- +migrate StatementEnd
+
+CREATE TRIGGER activeevent_inserted_scores_dirty AFTER INSERT ON activeevent
+REFERENCING NEW TABLE AS changed FOR EACH STATEMENT EXECUTE FUNCTION mark_daily_scores_dirty ();
+
+CREATE TRIGGER activeevent_deleted_scores_dirty AFTER DELETE ON activeevent
+REFERENCING OLD TABLE AS changed FOR EACH STATEMENT EXECUTE FUNCTION mark_daily_scores_dirty ();
+
+CREATE TRIGGER event_category_inserted_scores_dirty AFTER INSERT ON event_category
+REFERENCING NEW TABLE AS changed FOR EACH STATEMENT EXECUTE FUNCTION mark_daily_scores_dirty_by_category ();
+
+CREATE TRIGGER event_category_updated_scores_dirty AFTER UPDATE ON event_category
+REFERENCING NEW TABLE AS changed FOR EACH STATEMENT EXECUTE FUNCTION mark_daily_scores_dirty_by_category ();
+
+-- The weight of category, taken from its closest ancestor with one and 0 without any
+-- +migrate StatementBegin
+CREATE FUNCTION category_weight (category TEXT) RETURNS FLOAT LANGUAGE sql STABLE AS $$
+    SELECT COALESCE((
+        SELECT s.weight FROM category_score s
+        WHERE s.tenant_id = lifevisor_tenant ()
+            AND (s.category = category_weight.category OR left(category_weight.category, length(s.category) + 3) = s.category || ' > ')
+        ORDER BY length(s.category) DESC
+        LIMIT 1
+    ), 0)
+$$;
+-- +migrate StatementEnd
+
+-- Replaces the weights of the current tenant with weights, a JSON object of category to
+-- weight, and the time zone of its days. Returns false without touching anything when both
+-- are unchanged, otherwise every day is scored again on the next refresh.
+-- +migrate StatementBegin
+CREATE FUNCTION set_scoring (weights JSONB, tz TEXT) RETURNS BOOLEAN LANGUAGE plpgsql AS $$
+DECLARE
+    current_weights JSONB;
+    current_tz TEXT;
+BEGIN
+    SELECT COALESCE(jsonb_object_agg(category, weight), '{}') INTO current_weights
+    FROM category_score WHERE tenant_id = lifevisor_tenant ();
+    SELECT timezone INTO current_tz FROM score_setting WHERE tenant_id = lifevisor_tenant ();
+    IF current_weights = weights AND current_tz IS NOT DISTINCT FROM tz THEN
+        RETURN FALSE;
+    END IF;
+
+    -- fails on an unknown time zone before anything is replaced
+    PERFORM now() AT TIME ZONE tz;
+
+    DELETE FROM category_score WHERE tenant_id = lifevisor_tenant ();
+    INSERT INTO category_score (category, weight)
+    SELECT key, value::FLOAT FROM jsonb_each_text(weights);
+
+    INSERT INTO score_setting (timezone) VALUES (tz)
+    ON CONFLICT (tenant_id) DO UPDATE SET timezone = excluded.timezone;
+
+    INSERT INTO daily_scores_dirty (since) VALUES ('-infinity')
+    ON CONFLICT (tenant_id) DO UPDATE SET since = excluded.since;
+    RETURN TRUE;
+END
+$$;
+-- +migrate StatementEnd
+
+-- Scores every day from the stale mark of the current tenant on again and clears the mark,
+-- returns how many days were written
+-- +migrate StatementBegin
+CREATE FUNCTION refresh_daily_scores () RETURNS INT LANGUAGE plpgsql AS $$
+DECLARE
+    from_ts TIMESTAMP;
+    tz TEXT;
+    from_day DATE;
+    written INT;
+BEGIN
+    -- locking the mark makes concurrent writers wait and mark again after us
+    SELECT since INTO from_ts FROM daily_scores_dirty WHERE tenant_id = lifevisor_tenant () FOR UPDATE;
+    IF from_ts IS NULL THEN
+        RETURN 0;
+    END IF;
+
+    SELECT COALESCE((SELECT timezone FROM score_setting WHERE tenant_id = lifevisor_tenant ()), 'UTC') INTO tz;
+    -- activeevent.timestamp is UTC
+    from_day := ((from_ts AT TIME ZONE 'UTC') AT TIME ZONE tz)::DATE;
+
+    DELETE FROM daily_scores WHERE tenant_id = lifevisor_tenant () AND day >= from_day;
+
+    WITH segments AS (
+        SELECT a.timestamp AT TIME ZONE 'UTC' AS s,
+            (a.timestamp + a.duration * INTERVAL '1 second') AT TIME ZONE 'UTC' AS f,
+            category_weight (COALESCE(c.category, 'Uncategorized')) AS weight
+        FROM activeevent a
+        JOIN eventmodel e ON e.tenant_id = a.tenant_id AND e.device_id = a.device_id AND e.id = a.event_id
+        JOIN bucketmodel b ON b.tenant_id = e.tenant_id AND b.device_id = e.device_id AND b.key = e.bucket_id
+        LEFT JOIN event_category c ON c.tenant_id = a.tenant_id AND c.device_id = a.device_id AND c.event_id = a.event_id
+        WHERE a.tenant_id = lifevisor_tenant ()
+            AND b.type = 'currentwindow'
+            AND a.timestamp + a.duration * INTERVAL '1 second' > (from_day::TIMESTAMP AT TIME ZONE tz) AT TIME ZONE 'UTC'
+    ), parts AS (
+        -- split at local midnight
+        SELECT p.local::DATE AS day, segments.weight,
+            EXTRACT(EPOCH FROM LEAST(segments.f, (p.local + INTERVAL '1 day') AT TIME ZONE tz)
+                - GREATEST(segments.s, p.local AT TIME ZONE tz)) AS seconds
+        FROM segments
+        CROSS JOIN LATERAL generate_series(date_trunc('day', segments.s AT TIME ZONE tz), segments.f AT TIME ZONE tz, INTERVAL '1 day') AS p (local)
+    )
+    INSERT INTO daily_scores (day, active_seconds, productive_seconds, distracting_seconds, weighted_seconds, score)
+    SELECT day, SUM(seconds),
+        COALESCE(SUM(seconds) FILTER (WHERE weight > 0), 0),
+        COALESCE(SUM(seconds) FILTER (WHERE weight < 0), 0),
+        SUM(seconds * weight),
+        50 + 50 * SUM(seconds * weight) / SUM(seconds)
+    FROM parts
+    WHERE seconds > 0 AND day >= from_day
+    GROUP BY day;
+    GET DIAGNOSTICS written = ROW_COUNT;
+
+    DELETE FROM daily_scores_dirty WHERE tenant_id = lifevisor_tenant ();
+    RETURN written;
+END
+$$;
+-- +migrate StatementEnd
+
+-- The keys the current tenant spent the most active time on between start_at and end_at:
+-- apps for currentwindow buckets, host names of the url for web.tab.current buckets
+-- +migrate StatementBegin
+CREATE FUNCTION top_active (bucket_type TEXT, start_at TIMESTAMPTZ, end_at TIMESTAMPTZ, n INT)
+RETURNS TABLE (key TEXT, seconds FLOAT) LANGUAGE sql STABLE AS $$
+    SELECT COALESCE(CASE WHEN top_active.bucket_type = 'web.tab.current'
+            THEN substring(e.datastr->>'url' FROM '^[a-zA-Z][a-zA-Z0-9+.-]*://([^/:?#]+)')
+            ELSE e.datastr->>'app' END, '') AS key,
+        SUM(EXTRACT(EPOCH FROM LEAST(a.timestamp + a.duration * INTERVAL '1 second', end_at AT TIME ZONE 'UTC')
+            - GREATEST(a.timestamp, start_at AT TIME ZONE 'UTC')))::FLOAT AS seconds
+    FROM activeevent a
+    JOIN eventmodel e ON e.tenant_id = a.tenant_id AND e.device_id = a.device_id AND e.id = a.event_id
+    JOIN bucketmodel b ON b.tenant_id = e.tenant_id AND b.device_id = e.device_id AND b.key = e.bucket_id
+    WHERE a.tenant_id = lifevisor_tenant ()
+        AND b.type = top_active.bucket_type
+        AND a.timestamp < end_at AT TIME ZONE 'UTC'
+        AND a.timestamp + a.duration * INTERVAL '1 second' > start_at AT TIME ZONE 'UTC'
+    GROUP BY 1
+    ORDER BY 2 DESC
+    LIMIT n
+$$;
+-- +migrate StatementEnd
+
+-- The longest stretch of productive active window time between start_at and end_at, where
+-- leaving productive categories or the computer for up to a minute does not break the streak
+-- +migrate StatementBegin
+CREATE FUNCTION longest_focus_streak (start_at TIMESTAMPTZ, end_at TIMESTAMPTZ)
+RETURNS TABLE (started TIMESTAMPTZ, seconds FLOAT) LANGUAGE sql STABLE AS $$
+    WITH productive AS (
+        SELECT GREATEST(a.timestamp AT TIME ZONE 'UTC', start_at) AS s,
+            LEAST((a.timestamp + a.duration * INTERVAL '1 second') AT TIME ZONE 'UTC', end_at) AS f
+        FROM activeevent a
+        JOIN eventmodel e ON e.tenant_id = a.tenant_id AND e.device_id = a.device_id AND e.id = a.event_id
+        JOIN bucketmodel b ON b.tenant_id = e.tenant_id AND b.device_id = e.device_id AND b.key = e.bucket_id
+        LEFT JOIN event_category c ON c.tenant_id = a.tenant_id AND c.device_id = a.device_id AND c.event_id = a.event_id
+        WHERE a.tenant_id = lifevisor_tenant ()
+            AND b.type = 'currentwindow'
+            AND a.timestamp < end_at AT TIME ZONE 'UTC'
+            AND a.timestamp + a.duration * INTERVAL '1 second' > start_at AT TIME ZONE 'UTC'
+            AND category_weight (COALESCE(c.category, 'Uncategorized')) > 0
+    ), marked AS (
+        -- a streak starts wherever the gap to everything before is over a minute
+        SELECT s, f, COALESCE(s > MAX(f) OVER (ORDER BY s, f ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING) + INTERVAL '1 minute', TRUE) AS starts
+        FROM productive
+    ), streaks AS (
+        SELECT s, f, COUNT(*) FILTER (WHERE starts) OVER (ORDER BY s, f) AS streak
+        FROM marked
+    )
+    SELECT MIN(s), EXTRACT(EPOCH FROM MAX(f) - MIN(s))::FLOAT
+    FROM streaks
+    GROUP BY streak
+    ORDER BY 2 DESC
+    LIMIT 1
+$$;
+-- +migrate StatementEnd
+
+-- +migrate Down
+DROP FUNCTION IF EXISTS longest_focus_streak (TIMESTAMPTZ, TIMESTAMPTZ);
+DROP FUNCTION IF EXISTS top_active (TEXT, TIMESTAMPTZ, TIMESTAMPTZ, INT);
+DROP FUNCTION IF EXISTS refresh_daily_scores ();
+DROP FUNCTION IF EXISTS set_scoring (JSONB, TEXT);
+DROP FUNCTION IF EXISTS category_weight (TEXT);
+DROP TRIGGER IF EXISTS event_category_updated_scores_dirty ON event_category;
+DROP TRIGGER IF EXISTS event_category_inserted_scores_dirty ON event_category;
+DROP TRIGGER IF EXISTS activeevent_deleted_scores_dirty ON activeevent;
+DROP TRIGGER IF EXISTS activeevent_inserted_scores_dirty ON activeevent;
+DROP FUNCTION IF EXISTS mark_daily_scores_dirty_by_category ();
+DROP FUNCTION IF EXISTS mark_daily_scores_dirty ();
+DROP TABLE IF EXISTS daily_scores_dirty;
+DROP TABLE IF EXISTS daily_scores;
+DROP TABLE IF EXISTS score_setting;
+DROP TABLE IF EXISTS category_score;
@@ -0,0 +1,187 @@
+-- +migrate Up
+-- How sessions are found, per tenant. Without a row the defaults apply.
+CREATE TABLE session_setting (
+    tenant_id INT PRIMARY KEY DEFAULT lifevisor_tenant () REFERENCES tenant (id) ON DELETE CASCADE,
+    group_by TEXT NOT NULL DEFAULT 'category' CHECK (group_by IN ('category', 'app')),
+    min_seconds INT NOT NULL DEFAULT 1500 CHECK (min_seconds > 0), -- shortest session
+    max_gap_seconds INT NOT NULL DEFAULT 120 CHECK (max_gap_seconds >= 0) -- longest break within a session
+);
+
+-- Stretches of active window time on one device where one category or app dominates: its
+-- time is never interrupted by other windows or being away for max_gap_seconds or longer
+CREATE TABLE sessions (
+    tenant_id INT NOT NULL DEFAULT lifevisor_tenant () REFERENCES tenant (id) ON DELETE CASCADE,
+    device_id TEXT NOT NULL,
+    started TIMESTAMP NOT NULL,
+    ended TIMESTAMP NOT NULL,
+    key TEXT NOT NULL, -- The dominant category or app
+    focused_seconds FLOAT NOT NULL, -- Active time spent on key
+    interruptions INT NOT NULL, -- Windows of something else activated in between
+    PRIMARY KEY (tenant_id, device_id, started)
+);
+
+CREATE INDEX sessions_ended_idx ON sessions (tenant_id, ended);
+
+-- The earliest activity changed since sessions was last refreshed, per tenant
+CREATE TABLE sessions_dirty (
+    tenant_id INT PRIMARY KEY DEFAULT lifevisor_tenant () REFERENCES tenant (id) ON DELETE CASCADE,
+    since TIMESTAMP NOT NULL
+);
+
+INSERT INTO sessions_dirty (tenant_id, since)
+SELECT id, '-infinity' FROM tenant;
+
+ALTER TABLE session_setting ENABLE ROW LEVEL SECURITY;
+ALTER TABLE session_setting FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON session_setting USING (tenant_id = lifevisor_tenant ()) WITH CHECK (tenant_id = lifevisor_tenant ());
+
+ALTER TABLE sessions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sessions FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON sessions USING (tenant_id = lifevisor_tenant ()) WITH CHECK (tenant_id = lifevisor_tenant ());
+
+ALTER TABLE sessions_dirty ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sessions_dirty FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON sessions_dirty USING (tenant_id = lifevisor_tenant ()) WITH CHECK (tenant_id = lifevisor_tenant ());
+
+-- Refreshing active time or categories marks sessions stale from the earliest activity touched
+-- +migrate StatementBegin
+CREATE FUNCTION mark_sessions_dirty () RETURNS TRIGGER LANGUAGE plpgsql AS $$
+BEGIN
+    INSERT INTO sessions_dirty (tenant_id, since)
+    SELECT tenant_id, MIN(timestamp) FROM changed GROUP BY tenant_id
+    ON CONFLICT (tenant_id) DO UPDATE SET since = LEAST(sessions_dirty.since, excluded.since);
+    RETURN NULL;
+END
+$$;
+-- +migrate StatementEnd
+
+-- +migrate StatementBegin
+CREATE FUNCTION mark_sessions_dirty_by_category () RETURNS TRIGGER LANGUAGE plpgsql AS $$
+BEGIN
+    INSERT INTO sessions_dirty (tenant_id, since)
+    SELECT e.tenant_id, MIN(e.timestamp) FROM changed c
+    JOIN eventmodel e ON e.tenant_id = c.tenant_id AND e.device_id = c.device_id AND e.id = c.event_id
+    GROUP BY e.tenant_id
+    ON CONFLICT (tenant_id) DO UPDATE SET since = LEAST(sessions_dirty.since, excluded.since);
+    RETURN NULL;
+END
+$$;
+-- +migrate StatementEnd
+
+CREATE TRIGGER activeevent_inserted_sessions_dirty AFTER INSERT ON activeevent
+REFERENCING NEW TABLE AS changed FOR EACH STATEMENT EXECUTE FUNCTION mark_sessions_dirty ();
+
+CREATE TRIGGER activeevent_deleted_sessions_dirty AFTER DELETE ON activeevent
+REFERENCING OLD TABLE AS changed FOR EACH STATEMENT EXECUTE FUNCTION mark_sessions_dirty ();
+
+CREATE TRIGGER event_category_inserted_sessions_dirty AFTER INSERT ON event_category
+REFERENCING NEW TABLE AS changed FOR EACH STATEMENT EXECUTE FUNCTION mark_sessions_dirty_by_category ();
+
+CREATE TRIGGER event_category_updated_sessions_dirty AFTER UPDATE ON event_category
+REFERENCING NEW TABLE AS changed FOR EACH STATEMENT EXECUTE FUNCTION mark_sessions_dirty_by_category ();
+
+-- Replaces the session settings of the current tenant. Returns false without touching anything
+-- when they are unchanged, otherwise all sessions are found again on the next refresh.
+-- +migrate StatementBegin
+CREATE FUNCTION set_session_settings (new_group_by TEXT, new_min_seconds INT, new_max_gap_seconds INT) RETURNS BOOLEAN LANGUAGE plpgsql AS $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM session_setting
+        WHERE tenant_id = lifevisor_tenant ()
+            AND group_by = new_group_by AND min_seconds = new_min_seconds AND max_gap_seconds = new_max_gap_seconds
+    ) THEN
+        RETURN FALSE;
+    END IF;
+
+    INSERT INTO session_setting (group_by, min_seconds, max_gap_seconds) VALUES (new_group_by, new_min_seconds, new_max_gap_seconds)
+    ON CONFLICT (tenant_id) DO UPDATE
+    SET group_by = excluded.group_by, min_seconds = excluded.min_seconds, max_gap_seconds = excluded.max_gap_seconds;
+
+    INSERT INTO sessions_dirty (since) VALUES ('-infinity')
+    ON CONFLICT (tenant_id) DO UPDATE SET since = excluded.since;
+    RETURN TRUE;
+END
+$$;
+-- +migrate StatementEnd
+
+-- Finds the sessions around the activity changed since the stale mark of the current tenant and
+-- clears the mark, returns how many sessions were written. Activity up to a day before the mark
+-- is looked at again, so a session can only be cut short if it lasts longer than that.
+-- +migrate StatementBegin
+CREATE FUNCTION refresh_sessions () RETURNS INT LANGUAGE plpgsql AS $$
+DECLARE
+    from_ts TIMESTAMP;
+    cut TIMESTAMP;
+    setting session_setting%ROWTYPE;
+    written INT;
+BEGIN
+    -- locking the mark makes concurrent writers wait and mark again after us
+    SELECT since INTO from_ts FROM sessions_dirty WHERE tenant_id = lifevisor_tenant () FOR UPDATE;
+    IF from_ts IS NULL THEN
+        RETURN 0;
+    END IF;
+
+    SELECT * INTO setting FROM session_setting WHERE tenant_id = lifevisor_tenant ();
+    IF NOT FOUND THEN
+        setting.group_by := 'category';
+        setting.min_seconds := 1500;
+        setting.max_gap_seconds := 120;
+    END IF;
+
+    -- sessions ending after the cut start again from their beginning
+    cut := from_ts - INTERVAL '1 day';
+    cut := LEAST(cut, (SELECT MIN(started) FROM sessions WHERE tenant_id = lifevisor_tenant () AND ended > cut));
+    DELETE FROM sessions WHERE tenant_id = lifevisor_tenant () AND started >= cut;
+
+    WITH segments AS (
+        SELECT a.device_id, a.timestamp AS s, a.timestamp + a.duration * INTERVAL '1 second' AS f, a.duration,
+            CASE WHEN setting.group_by = 'app' THEN COALESCE(e.datastr->>'app', '') ELSE COALESCE(c.category, 'Uncategorized') END AS key
+        FROM activeevent a
+        JOIN eventmodel e ON e.tenant_id = a.tenant_id AND e.device_id = a.device_id AND e.id = a.event_id
+        JOIN bucketmodel b ON b.tenant_id = e.tenant_id AND b.device_id = e.device_id AND b.key = e.bucket_id
+        LEFT JOIN event_category c ON c.tenant_id = a.tenant_id AND c.device_id = a.device_id AND c.event_id = a.event_id
+        WHERE a.tenant_id = lifevisor_tenant ()
+            AND b.type = 'currentwindow'
+            AND a.timestamp + a.duration * INTERVAL '1 second' > cut
+    ), marked AS (
+        -- a run of a key starts wherever the gap to its time before is max_gap_seconds or more
+        SELECT segments.*, COALESCE(s >= MAX(f) OVER (PARTITION BY device_id, key ORDER BY s, f ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING)
+            + setting.max_gap_seconds * INTERVAL '1 second', TRUE) AS starts
+        FROM segments
+    ), runs AS (
+        SELECT device_id, key, MIN(s) AS started, MAX(f) AS ended, SUM(duration) AS focused
+        FROM (
+            SELECT marked.*, COUNT(*) FILTER (WHERE starts) OVER (PARTITION BY device_id, key ORDER BY s, f) AS run
+            FROM marked
+        ) numbered
+        GROUP BY device_id, key, run
+    )
+    INSERT INTO sessions (device_id, started, ended, key, focused_seconds, interruptions)
+    SELECT r.device_id, r.started, r.ended, r.key, r.focused,
+        (SELECT COUNT(*) FROM segments o WHERE o.device_id = r.device_id AND o.key <> r.key AND o.s >= r.started AND o.s < r.ended)
+    FROM runs r
+    WHERE r.started >= cut
+        AND EXTRACT(EPOCH FROM r.ended - r.started) >= setting.min_seconds
+        -- dominant, most of the time went to key
+        AND r.focused * 2 > EXTRACT(EPOCH FROM r.ended - r.started)
+    ON CONFLICT (tenant_id, device_id, started) DO NOTHING;
+    GET DIAGNOSTICS written = ROW_COUNT;
+
+    DELETE FROM sessions_dirty WHERE tenant_id = lifevisor_tenant ();
+    RETURN written;
+END
+$$;
+-- +migrate StatementEnd
+
+-- +migrate Down
+DROP FUNCTION IF EXISTS refresh_sessions ();
+DROP FUNCTION IF EXISTS set_session_settings (TEXT, INT, INT);
+DROP TRIGGER IF EXISTS event_category_updated_sessions_dirty ON event_category;
+DROP TRIGGER IF EXISTS event_category_inserted_sessions_dirty ON event_category;
+DROP TRIGGER IF EXISTS activeevent_deleted_sessions_dirty ON activeevent;
+DROP TRIGGER IF EXISTS activeevent_inserted_sessions_dirty ON activeevent;
+DROP FUNCTION IF EXISTS mark_sessions_dirty_by_category ();
+DROP FUNCTION IF EXISTS mark_sessions_dirty ();
+DROP TABLE IF EXISTS sessions_dirty;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS session_setting;
@@ -0,0 +1,152 @@
+-- +migrate Up
+-- Every change of the focused app, between consecutive events of a currentwindow bucket
+CREATE TABLE app_switch (
+    tenant_id INT NOT NULL DEFAULT lifevisor_tenant (),
+    device_id TEXT NOT NULL,
+    event_id INT NOT NULL, -- The window event switched to
+    switched_at TIMESTAMP NOT NULL,
+    from_app TEXT NOT NULL,
+    to_app TEXT NOT NULL,
+    PRIMARY KEY (tenant_id, device_id, event_id),
+    FOREIGN KEY (tenant_id, device_id, event_id) REFERENCES eventmodel (tenant_id, device_id, id) ON DELETE CASCADE
+);
+
+CREATE INDEX app_switch_switched_at_idx ON app_switch (tenant_id, switched_at);
+
+-- The earliest event timestamp written since app_switch was last refreshed, per tenant
+CREATE TABLE app_switch_dirty (
+    tenant_id INT PRIMARY KEY DEFAULT lifevisor_tenant () REFERENCES tenant (id) ON DELETE CASCADE,
+    since TIMESTAMP NOT NULL
+);
+
+INSERT INTO app_switch_dirty (tenant_id, since)
+SELECT id, '-infinity' FROM tenant;
+
+ALTER TABLE app_switch ENABLE ROW LEVEL SECURITY;
+ALTER TABLE app_switch FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON app_switch USING (tenant_id = lifevisor_tenant ()) WITH CHECK (tenant_id = lifevisor_tenant ());
+
+ALTER TABLE app_switch_dirty ENABLE ROW LEVEL SECURITY;
+ALTER TABLE app_switch_dirty FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON app_switch_dirty USING (tenant_id = lifevisor_tenant ()) WITH CHECK (tenant_id = lifevisor_tenant ());
+
+-- Every write to eventmodel marks app_switch stale from the earliest event it touched
+-- +migrate StatementBegin
+CREATE FUNCTION mark_app_switch_dirty () RETURNS TRIGGER LANGUAGE plpgsql AS $$
+BEGIN
+    INSERT INTO app_switch_dirty (tenant_id, since)
+    SELECT tenant_id, MIN(timestamp) FROM changed GROUP BY tenant_id
+    ON CONFLICT (tenant_id) DO UPDATE SET since = LEAST(app_switch_dirty.since, excluded.since);
+    RETURN NULL;
+END
+$$;
+-- +migrate StatementEnd
+
+CREATE TRIGGER eventmodel_inserted_switch_dirty AFTER INSERT ON eventmodel
+REFERENCING NEW TABLE AS changed FOR EACH STATEMENT EXECUTE FUNCTION mark_app_switch_dirty ();
+
+CREATE TRIGGER eventmodel_updated_switch_dirty AFTER UPDATE ON eventmodel
+REFERENCING NEW TABLE AS changed FOR EACH STATEMENT EXECUTE FUNCTION mark_app_switch_dirty ();
+
+-- Finds the app switches of the window events starting at or after the stale mark of the current
+-- tenant and clears the mark, returns how many switches were written. An event more than a day
+-- after the one before it is not counted as a switch.
+-- +migrate StatementBegin
+CREATE FUNCTION refresh_app_switches () RETURNS INT LANGUAGE plpgsql AS $$
+DECLARE
+    from_ts TIMESTAMP;
+    written INT;
+BEGIN
+    -- locking the mark makes concurrent writers wait and mark again after us
+    SELECT since INTO from_ts FROM app_switch_dirty WHERE tenant_id = lifevisor_tenant () FOR UPDATE;
+    IF from_ts IS NULL THEN
+        RETURN 0;
+    END IF;
+
+    DELETE FROM app_switch WHERE tenant_id = lifevisor_tenant () AND switched_at >= from_ts;
+
+    WITH ordered AS (
+        SELECT e.device_id, e.id, e.timestamp, COALESCE(e.datastr->>'app', '') AS app,
+            LAG(COALESCE(e.datastr->>'app', '')) OVER w AS previous_app,
+            LAG(e.timestamp) OVER w AS previous_timestamp
+        FROM eventmodel e
+        JOIN bucketmodel b ON b.tenant_id = e.tenant_id AND b.device_id = e.device_id AND b.key = e.bucket_id
+        WHERE e.tenant_id = lifevisor_tenant ()
+            AND b.type = 'currentwindow'
+            -- the events before the mark are only needed to compare with
+            AND e.timestamp >= from_ts - INTERVAL '1 day'
+        WINDOW w AS (PARTITION BY e.device_id, e.bucket_id ORDER BY e.timestamp, e.id)
+    )
+    INSERT INTO app_switch (device_id, event_id, switched_at, from_app, to_app)
+    SELECT device_id, id, timestamp, previous_app, app
+    FROM ordered
+    WHERE timestamp >= from_ts AND previous_app <> app
+        -- the same within the lookback as before it, so a full and an incremental refresh agree
+        AND timestamp - previous_timestamp <= INTERVAL '1 day';
+    GET DIAGNOSTICS written = ROW_COUNT;
+
+    DELETE FROM app_switch_dirty WHERE tenant_id = lifevisor_tenant ();
+    RETURN written;
+END
+$$;
+-- +migrate StatementEnd
+
+-- App switches and active window time between start_at and end_at per hour of the day in tz
+-- +migrate StatementBegin
+CREATE FUNCTION switches_by_hour (start_at TIMESTAMPTZ, end_at TIMESTAMPTZ, tz TEXT)
+RETURNS TABLE (hour INT, switches INT, active_seconds FLOAT) LANGUAGE sql STABLE AS $$
+    WITH active AS (
+        SELECT a.timestamp AT TIME ZONE 'UTC' AS s, (a.timestamp + a.duration * INTERVAL '1 second') AT TIME ZONE 'UTC' AS f
+        FROM activeevent a
+        JOIN eventmodel e ON e.tenant_id = a.tenant_id AND e.device_id = a.device_id AND e.id = a.event_id
+        JOIN bucketmodel b ON b.tenant_id = e.tenant_id AND b.device_id = e.device_id AND b.key = e.bucket_id
+        WHERE a.tenant_id = lifevisor_tenant ()
+            AND b.type = 'currentwindow'
+            AND a.timestamp < end_at AT TIME ZONE 'UTC'
+            AND a.timestamp + a.duration * INTERVAL '1 second' > start_at AT TIME ZONE 'UTC'
+    ), seconds AS (
+        -- split at the hour in tz
+        SELECT EXTRACT(HOUR FROM p.local)::INT AS hour,
+            SUM(EXTRACT(EPOCH FROM LEAST(active.f, (p.local + INTERVAL '1 hour') AT TIME ZONE tz, end_at)
+                - GREATEST(active.s, p.local AT TIME ZONE tz, start_at)))::FLOAT AS active_seconds
+        FROM active
+        CROSS JOIN LATERAL generate_series(date_trunc('hour', active.s AT TIME ZONE tz), active.f AT TIME ZONE tz, INTERVAL '1 hour') AS p (local)
+        GROUP BY 1
+    ), counted AS (
+        SELECT EXTRACT(HOUR FROM (s.switched_at AT TIME ZONE 'UTC') AT TIME ZONE tz)::INT AS hour, COUNT(*)::INT AS switches
+        FROM app_switch s
+        WHERE s.tenant_id = lifevisor_tenant ()
+            AND s.switched_at >= start_at AT TIME ZONE 'UTC'
+            AND s.switched_at < end_at AT TIME ZONE 'UTC'
+        GROUP BY 1
+    )
+    SELECT COALESCE(seconds.hour, counted.hour), COALESCE(counted.switches, 0), COALESCE(seconds.active_seconds, 0)
+    FROM seconds FULL JOIN counted ON counted.hour = seconds.hour
+    ORDER BY 1
+$$;
+-- +migrate StatementEnd
+
+-- The n most frequent app to app switches between start_at and end_at
+-- +migrate StatementBegin
+CREATE FUNCTION top_transitions (start_at TIMESTAMPTZ, end_at TIMESTAMPTZ, n INT)
+RETURNS TABLE (from_app TEXT, to_app TEXT, switches INT) LANGUAGE sql STABLE AS $$
+    SELECT s.from_app, s.to_app, COUNT(*)::INT
+    FROM app_switch s
+    WHERE s.tenant_id = lifevisor_tenant ()
+        AND s.switched_at >= start_at AT TIME ZONE 'UTC'
+        AND s.switched_at < end_at AT TIME ZONE 'UTC'
+    GROUP BY s.from_app, s.to_app
+    ORDER BY 3 DESC, 1, 2
+    LIMIT n
+$$;
+-- +migrate StatementEnd
+
+-- +migrate Down
+DROP FUNCTION IF EXISTS top_transitions (TIMESTAMPTZ, TIMESTAMPTZ, INT);
+DROP FUNCTION IF EXISTS switches_by_hour (TIMESTAMPTZ, TIMESTAMPTZ, TEXT);
+DROP FUNCTION IF EXISTS refresh_app_switches ();
+DROP TRIGGER IF EXISTS eventmodel_updated_switch_dirty ON eventmodel;
+DROP TRIGGER IF EXISTS eventmodel_inserted_switch_dirty ON eventmodel;
+DROP FUNCTION IF EXISTS mark_app_switch_dirty ();
+DROP TABLE IF EXISTS app_switch_dirty;
+DROP TABLE IF EXISTS app_switch;
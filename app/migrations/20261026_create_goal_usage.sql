@@ -0,0 +1,31 @@
+-- +migrate Up
+-- Active time between start_at and end_at of the current tenant spent on target, which is an app
+-- (kind 'app'), a site and its subdomains ('site') or a category and its subcategories ('category').
+-- A category matches on whole levels anywhere in the path, 'Programming' counts 'Work > Programming'.
+-- +migrate StatementBegin
+CREATE FUNCTION goal_usage (kind TEXT, target TEXT, start_at TIMESTAMPTZ, end_at TIMESTAMPTZ) RETURNS FLOAT LANGUAGE sql STABLE AS $$
+    SELECT COALESCE(SUM(EXTRACT(EPOCH FROM LEAST(a.timestamp + a.duration * INTERVAL '1 second', end_at AT TIME ZONE 'UTC')
+        - GREATEST(a.timestamp, start_at AT TIME ZONE 'UTC'))), 0)::FLOAT
+    FROM activeevent a
+    JOIN eventmodel e ON e.tenant_id = a.tenant_id AND e.device_id = a.device_id AND e.id = a.event_id
+    JOIN bucketmodel b ON b.tenant_id = e.tenant_id AND b.device_id = e.device_id AND b.key = e.bucket_id
+    LEFT JOIN event_category c ON c.tenant_id = a.tenant_id AND c.device_id = a.device_id AND c.event_id = a.event_id
+    CROSS JOIN LATERAL (
+        SELECT lower(substring(e.datastr->>'url' FROM '^[a-zA-Z][a-zA-Z0-9+.-]*://([^/:?#]+)')) AS host
+    ) u
+    WHERE a.tenant_id = lifevisor_tenant ()
+        AND a.timestamp < end_at AT TIME ZONE 'UTC'
+        AND a.timestamp + a.duration * INTERVAL '1 second' > start_at AT TIME ZONE 'UTC'
+        AND CASE goal_usage.kind
+            WHEN 'app' THEN b.type = 'currentwindow' AND lower(e.datastr->>'app') = lower(goal_usage.target)
+            WHEN 'site' THEN b.type = 'web.tab.current'
+                AND (u.host = lower(goal_usage.target) OR right(u.host, length(goal_usage.target) + 1) = '.' || lower(goal_usage.target))
+            WHEN 'category' THEN b.type = 'currentwindow'
+                AND position(' > ' || goal_usage.target || ' > ' IN ' > ' || COALESCE(c.category, 'Uncategorized') || ' > ') > 0
+            ELSE FALSE
+        END
+$$;
+-- +migrate StatementEnd
+
+-- +migrate Down
+DROP FUNCTION IF EXISTS goal_usage (TEXT, TEXT, TIMESTAMPTZ, TIMESTAMPTZ);
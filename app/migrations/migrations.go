@@ -0,0 +1,8 @@
+package migrations
+
+import "embed"
+
+// FS holds the SQL migrations so the binary can run them from any working directory
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/azaurus1/lifevisor-service/internal/data"
+)
+
+const adminUsage = `usage: lifevisor-service [command]
+
+Without a command the service starts serving on :8080.
+
+Commands:
+  create-user <name>            create a user, syncing into their own tenant
+  list-users                    list users
+  create-token <user> <name>    create a bearer token for a user, printed once
+  revoke-token <id>             revoke a token so it is no longer accepted
+  list-tokens                   list tokens with their user and when they were last used
+`
+
+// runAdmin runs an admin command against the database and returns the exit code
+func runAdmin(repo data.Repository, args []string) int {
+	err := admin(repo, args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func admin(repo data.Repository, args []string) error {
+	switch args[0] {
+	case "create-user":
+		if len(args) != 2 || args[1] == "" {
+			return errors.New("usage: lifevisor-service create-user <name>")
+		}
+
+		tenant, err := repo.CreateTenant(args[1])
+		if err != nil {
+			return fmt.Errorf("error creating user: %w", err)
+		}
+
+		fmt.Printf("Created user %d (%s)\n", tenant.ID, tenant.Name)
+		return nil
+
+	case "list-users":
+		tenants, err := repo.ListTenants()
+		if err != nil {
+			return fmt.Errorf("error listing users: %w", err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tCREATED")
+		for _, tenant := range tenants {
+			fmt.Fprintf(w, "%d\t%s\t%s\n", tenant.ID, tenant.Name, tenant.Created.Format(time.DateTime))
+		}
+		return w.Flush()
+
+	case "create-token":
+		if len(args) != 3 || args[1] == "" || args[2] == "" {
+			return errors.New("usage: lifevisor-service create-token <user> <name>")
+		}
+
+		token, hash, err := newToken()
+		if err != nil {
+			return fmt.Errorf("error generating token: %w", err)
+		}
+		created, err := repo.CreateToken(args[1], args[2], token[:tokenPrefixLen], hash)
+		if err != nil {
+			return fmt.Errorf("error storing token: %w", err)
+		}
+
+		fmt.Fprintf(os.Stderr, "Created token %d (%s) for %s, it is not shown again:\n", created.ID, created.Name, created.Tenant)
+		fmt.Println(token)
+		return nil
+
+	case "revoke-token":
+		if len(args) != 2 {
+			return errors.New("usage: lifevisor-service revoke-token <id>")
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("token id must be a number: %s", args[1])
+		}
+
+		err = repo.RevokeToken(id)
+		if err != nil {
+			return fmt.Errorf("error revoking token %d: %w", id, err)
+		}
+
+		fmt.Printf("Revoked token %d\n", id)
+		return nil
+
+	case "list-tokens":
+		tokens, err := repo.ListTokens()
+		if err != nil {
+			return fmt.Errorf("error listing tokens: %w", err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tUSER\tNAME\tPREFIX\tCREATED\tLAST USED\tREVOKED")
+		for _, token := range tokens {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s…\t%s\t%s\t%s\n", token.ID, token.Tenant, token.Name, token.Prefix,
+				token.Created.Format(time.DateTime), formatOptionalTime(token.LastUsed), formatOptionalTime(token.Revoked))
+		}
+		return w.Flush()
+
+	case "help", "-h", "--help":
+		fmt.Print(adminUsage)
+		return nil
+
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", args[0], adminUsage)
+	}
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.DateTime)
+}
+
+func hasActiveToken(tokens []data.APIToken) bool {
+	for _, token := range tokens {
+		if token.Revoked == nil {
+			return true
+		}
+	}
+	return false
+}
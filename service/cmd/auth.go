@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/azaurus1/lifevisor-service/internal/data"
+)
+
+const (
+	tokenPrefix = "lv_"
+	// characters of a token kept in the clear to tell tokens apart
+	tokenPrefixLen = len(tokenPrefix) + 6
+)
+
+// newToken returns a random bearer token and its hash
+func newToken() (string, string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	token := tokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	return token, hashToken(token), nil
+}
+
+// hashToken is what the database stores instead of the token, tokens are random
+// so a plain SHA-256 is enough to keep them from being read back
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RequireToken rejects requests without the Authorization: Bearer header of an active token
+func (app *Config) RequireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="lifevisor"`)
+			http.Error(w, "Missing bearer token", http.StatusUnauthorized)
+			return
+		}
+
+		apiToken, err := app.Repo.AuthenticateToken(hashToken(strings.TrimSpace(token)))
+		if errors.Is(err, data.ErrTokenNotFound) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="lifevisor", error="invalid_token"`)
+			http.Error(w, "Invalid or revoked token", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Printf("Error checking token: %v", err)
+			http.Error(w, "Error checking token", http.StatusInternalServerError)
+			return
+		}
+
+		// everything the handlers read and write is scoped to the tenant of the token
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey, apiToken.TenantID)))
+	})
+}
+
+type contextKey int
+
+const tenantKey contextKey = 0
+
+// tenantID returns the tenant RequireToken authenticated the request as
+func tenantID(r *http.Request) int {
+	return r.Context().Value(tenantKey).(int)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/azaurus1/lifevisor-service/internal/data"
+)
+
+// ListCategoryRules returns the category rules of the tenant in the order they apply
+func (app *Config) ListCategoryRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := app.Repo.ListCategoryRules(tenantID(r))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading category rules: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if rules == nil {
+		rules = []data.CategoryRule{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// SetCategoryRules replaces the category rules of the tenant with the JSON array in the body
+func (app *Config) SetCategoryRules(w http.ResponseWriter, r *http.Request) {
+	var rules []data.CategoryRule
+	err := json.NewDecoder(r.Body).Decode(&rules)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error unmarshalling category rules: %v", err), http.StatusBadRequest)
+		return
+	}
+	for i, rule := range rules {
+		if rule.Category == "" || rule.Regex == "" {
+			http.Error(w, fmt.Sprintf("Category rule %d needs both a Category and a Regex", i+1), http.StatusBadRequest)
+			return
+		}
+	}
+
+	changed, err := app.Repo.SetCategoryRules(tenantID(r), rules)
+	if errors.Is(err, data.ErrInvalidRegex) {
+		http.Error(w, fmt.Sprintf("Invalid category rules: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error storing category rules: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{ Changed bool }{changed})
+}
+
+// RefreshCategories categorises the events written since the last refresh, clients call it after syncing
+func (app *Config) RefreshCategories(w http.ResponseWriter, r *http.Request) {
+	n, err := app.Repo.RefreshEventCategories(tenantID(r))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error categorising events: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{ Written int }{n})
+}
+
+// RecomputeCategories categorises every event of the tenant again
+func (app *Config) RecomputeCategories(w http.ResponseWriter, r *http.Request) {
+	n, err := app.Repo.RecategorizeEvents(tenantID(r))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error recategorising events: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{ Written int }{n})
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 
 	"github.com/azaurus1/lifevisor-service/internal/data"
@@ -16,7 +19,7 @@ func (app *Config) UploadBucket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.Repo.InsertBucket(bucket)
+	err = app.Repo.InsertBucket(tenantID(r), bucket)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error inserting bucket: %v", err), http.StatusInternalServerError)
 		return
@@ -34,7 +37,7 @@ func (app *Config) UploadEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.Repo.InsertEvent(event)
+	err = app.Repo.InsertEvent(tenantID(r), event)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error inserting event: %v", err), http.StatusInternalServerError)
 		return
@@ -43,3 +46,121 @@ func (app *Config) UploadEvent(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Event uploaded successfully"))
 }
+
+func (app *Config) UploadBucketBatch(w http.ResponseWriter, r *http.Request) {
+	buckets, err := decodeBatch[data.Bucket](r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error unmarshalling bucket batch: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	err = app.Repo.InsertBuckets(tenantID(r), buckets)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error inserting buckets: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf("%d buckets uploaded successfully", len(buckets))))
+}
+
+func (app *Config) UploadEventBatch(w http.ResponseWriter, r *http.Request) {
+	events, err := decodeBatch[data.Event](r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error unmarshalling event batch: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	result, err := app.Repo.InsertEvents(tenantID(r), events)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error inserting events: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// tell the client how many events were new or changed
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// decodeBatch reads a request body holding either a JSON array or newline-delimited JSON objects
+func decodeBatch[T any](body io.Reader) ([]T, error) {
+	reader := bufio.NewReader(body)
+
+	// look at the first non-whitespace byte to tell the two formats apart
+	var first byte
+	for {
+		b, err := reader.Peek(1)
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b[0] != ' ' && b[0] != '\t' && b[0] != '\r' && b[0] != '\n' {
+			first = b[0]
+			break
+		}
+		reader.Discard(1)
+	}
+
+	decoder := json.NewDecoder(reader)
+
+	if first == '[' {
+		var items []T
+		err := decoder.Decode(&items)
+		return items, err
+	}
+
+	var items []T
+	for {
+		var item T
+		err := decoder.Decode(&item)
+		if errors.Is(err, io.EOF) {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+func (app *Config) GetSyncStates(w http.ResponseWriter, r *http.Request) {
+	deviceID := r.URL.Query().Get("device")
+	if deviceID == "" {
+		http.Error(w, "Missing device query parameter", http.StatusBadRequest)
+		return
+	}
+
+	states, err := app.Repo.GetSyncStates(tenantID(r), deviceID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading sync state: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]data.SyncState, 0, len(states))
+	for _, state := range states {
+		list = append(list, state)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (app *Config) UpdateSyncState(w http.ResponseWriter, r *http.Request) {
+	var state data.SyncState
+	err := json.NewDecoder(r.Body).Decode(&state)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error unmarshalling sync state: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	err = app.Repo.UpdateSyncState(tenantID(r), state)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error updating sync state: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Sync state updated successfully"))
+}
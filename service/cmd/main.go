@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	_ "time/tzdata" // ?tz= time zones on images without a zoneinfo database
 
 	"github.com/azaurus1/lifevisor-service/internal/data"
 )
@@ -35,12 +36,46 @@ func main() {
 		log.Fatal("Error running migrations: ", err)
 	}
 
+	// admin commands manage the database and exit
+	if len(os.Args) > 1 {
+		os.Exit(runAdmin(app.Repo, os.Args[1:]))
+	}
+
+	tokens, err := app.Repo.ListTokens()
+	if err != nil {
+		log.Fatal("Error reading tokens: ", err)
+	}
+	if !hasActiveToken(tokens) {
+		log.Println("No active API tokens, every request will be rejected until one is created with: lifevisor-service create-token <user> <name>")
+	}
+
 	http.HandleFunc("/buckets", app.UploadBucket)
 	http.HandleFunc("/events", app.UploadEvent)
+	http.HandleFunc("POST /v1/buckets:batch", app.UploadBucketBatch)
+	http.HandleFunc("POST /v1/events:batch", app.UploadEventBatch)
+	http.HandleFunc("GET /sync-state", app.GetSyncStates)
+	http.HandleFunc("POST /sync-state", app.UpdateSyncState)
+	http.HandleFunc("GET /v1/buckets", app.ListBuckets)
+	http.HandleFunc("GET /v1/buckets/{id}/events", app.ListEvents)
+	http.HandleFunc("GET /v1/summary", app.Summary)
+	http.HandleFunc("POST /v1/active:refresh", app.RefreshActive)
+	http.HandleFunc("GET /v1/categories/rules", app.ListCategoryRules)
+	http.HandleFunc("PUT /v1/categories/rules", app.SetCategoryRules)
+	http.HandleFunc("POST /v1/categories:refresh", app.RefreshCategories)
+	http.HandleFunc("POST /v1/categories:recompute", app.RecomputeCategories)
+	http.HandleFunc("PUT /v1/scoring", app.SetScoring)
+	http.HandleFunc("POST /v1/scores:refresh", app.RefreshScores)
+	http.HandleFunc("GET /v1/report", app.Report)
+	http.HandleFunc("PUT /v1/sessions/settings", app.SetSessionSettings)
+	http.HandleFunc("POST /v1/sessions:refresh", app.RefreshSessions)
+	http.HandleFunc("GET /v1/sessions", app.ListSessions)
+	http.HandleFunc("POST /v1/switches:refresh", app.RefreshSwitches)
+	http.HandleFunc("GET /v1/switches", app.Switches)
+	http.HandleFunc("GET /v1/usage", app.GoalUsage)
 
 	app.Server = &http.Server{
 		Addr:    ":8080",
-		Handler: nil,
+		Handler: app.RequireToken(http.DefaultServeMux),
 	}
 
 	log.Println("Server starting on :8080")
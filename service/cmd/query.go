@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/azaurus1/lifevisor-service/internal/data"
+)
+
+const (
+	defaultEventLimit = 100
+	maxEventLimit     = 1000
+)
+
+// EventPage is one page of events, NextCursor is set when there may be more
+type EventPage struct {
+	Events     []data.Event
+	NextCursor string `json:",omitempty"`
+}
+
+// ListBuckets returns the buckets of the tenant, of one device with ?device=
+func (app *Config) ListBuckets(w http.ResponseWriter, r *http.Request) {
+	buckets, err := app.Repo.ListBuckets(tenantID(r), r.URL.Query().Get("device"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading buckets: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if buckets == nil {
+		buckets = []data.Bucket{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buckets)
+}
+
+// ListEvents returns a page of the events of a bucket ordered by timestamp, taking
+// ?start= and ?end= as RFC 3339 times, ?limit=, ?device= and the ?cursor= of the previous page
+func (app *Config) ListEvents(w http.ResponseWriter, r *http.Request) {
+	query, err := parseEventQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, err := app.Repo.ListEvents(tenantID(r), query)
+	if errors.Is(err, data.ErrBucketNotFound) {
+		http.Error(w, fmt.Sprintf("Bucket %q not found", query.BucketID), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading events: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	page := EventPage{Events: events}
+	if page.Events == nil {
+		page.Events = []data.Event{}
+	}
+	if len(events) == query.Limit {
+		last := events[len(events)-1]
+		page.NextCursor = encodeCursor(data.EventCursor{Timestamp: last.Timestamp, DeviceID: last.DeviceID, ID: last.ID})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func parseEventQuery(r *http.Request) (data.EventQuery, error) {
+	params := r.URL.Query()
+	query := data.EventQuery{
+		BucketID: r.PathValue("id"),
+		DeviceID: params.Get("device"),
+		Limit:    defaultEventLimit,
+	}
+
+	var err error
+	if value := params.Get("start"); value != "" {
+		query.Start, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return query, fmt.Errorf("Invalid start, expected an RFC 3339 time: %v", err)
+		}
+	}
+	if value := params.Get("end"); value != "" {
+		query.End, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return query, fmt.Errorf("Invalid end, expected an RFC 3339 time: %v", err)
+		}
+	}
+	if value := params.Get("limit"); value != "" {
+		query.Limit, err = strconv.Atoi(value)
+		if err != nil || query.Limit < 1 || query.Limit > maxEventLimit {
+			return query, fmt.Errorf("Invalid limit, expected a number from 1 to %d", maxEventLimit)
+		}
+	}
+	if value := params.Get("cursor"); value != "" {
+		cursor, err := decodeCursor(value)
+		if err != nil {
+			return query, fmt.Errorf("Invalid cursor: %v", err)
+		}
+		query.After = &cursor
+	}
+
+	return query, nil
+}
+
+// cursors are opaque to clients, they only pass back what the previous page returned
+func encodeCursor(cursor data.EventCursor) string {
+	payload, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+func decodeCursor(value string) (data.EventCursor, error) {
+	var cursor data.EventCursor
+	payload, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(payload, &cursor)
+	return cursor, err
+}
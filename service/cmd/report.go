@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/azaurus1/lifevisor-service/internal/data"
+)
+
+// SetScoring replaces the category weights and time zone of the daily scores of the tenant
+func (app *Config) SetScoring(w http.ResponseWriter, r *http.Request) {
+	var scoring data.Scoring
+	err := json.NewDecoder(r.Body).Decode(&scoring)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error unmarshalling scoring: %v", err), http.StatusBadRequest)
+		return
+	}
+	if scoring.TimeZone == "" {
+		scoring.TimeZone = "UTC"
+	}
+	if _, err := time.LoadLocation(scoring.TimeZone); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid TimeZone, expected an IANA time zone such as Europe/Berlin: %v", err), http.StatusBadRequest)
+		return
+	}
+	for category, weight := range scoring.Weights {
+		if weight < -1 || weight > 1 {
+			http.Error(w, fmt.Sprintf("Weight of %q must be between -1 and 1", category), http.StatusBadRequest)
+			return
+		}
+	}
+
+	changed, err := app.Repo.SetScoring(tenantID(r), scoring)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error storing scoring: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{ Changed bool }{changed})
+}
+
+// RefreshScores scores the days changed since the last refresh, clients call it after syncing
+func (app *Config) RefreshScores(w http.ResponseWriter, r *http.Request) {
+	n, err := app.Repo.RefreshDailyScores(tenantID(r))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error refreshing daily scores: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{ Written int }{n})
+}
+
+// Report returns the daily scores, top apps and sites and longest focus streak between ?start=
+// and ?end=, the last seven days by default, with days in the ?tz= time zone. ?top= limits the
+// apps and sites listed.
+func (app *Config) Report(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	start, end, err := parseRange(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	top := 5
+	if value := params.Get("top"); value != "" {
+		top, err = strconv.Atoi(value)
+		if err != nil || top < 1 || top > 100 {
+			http.Error(w, "Invalid top, expected an integer between 1 and 100", http.StatusBadRequest)
+			return
+		}
+	}
+
+	report, err := app.Repo.Report(tenantID(r), start, end, top)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading report: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// parseRange reads ?start= and ?end= as RFC 3339 times or dates in the ?tz= time zone, the last
+// seven days up to now by default. Both are returned in that time zone.
+func parseRange(params url.Values) (time.Time, time.Time, error) {
+	location := time.UTC
+	if tz := params.Get("tz"); tz != "" {
+		var err error
+		location, err = time.LoadLocation(tz)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("Invalid tz, expected an IANA time zone such as Europe/Berlin: %v", err)
+		}
+	}
+
+	now := time.Now().In(location)
+	end := now
+	start := time.Date(now.Year(), now.Month(), now.Day()-6, 0, 0, 0, 0, location)
+
+	var err error
+	if value := params.Get("start"); value != "" {
+		start, err = parseSummaryTime(value, location)
+		if err != nil {
+			return start, end, fmt.Errorf("Invalid start: %v", err)
+		}
+	}
+	if value := params.Get("end"); value != "" {
+		end, err = parseSummaryTime(value, location)
+		if err != nil {
+			return start, end, fmt.Errorf("Invalid end: %v", err)
+		}
+	}
+	if !start.Before(end) {
+		return start, end, fmt.Errorf("Invalid range, start must be before end")
+	}
+
+	return start.In(location), end.In(location), nil
+}
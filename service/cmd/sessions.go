@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/azaurus1/lifevisor-service/internal/data"
+)
+
+// SetSessionSettings replaces how the sessions of the tenant are found
+func (app *Config) SetSessionSettings(w http.ResponseWriter, r *http.Request) {
+	var settings data.SessionSettings
+	err := json.NewDecoder(r.Body).Decode(&settings)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error unmarshalling session settings: %v", err), http.StatusBadRequest)
+		return
+	}
+	if settings.GroupBy != "category" && settings.GroupBy != "app" {
+		http.Error(w, "Invalid GroupBy, expected category or app", http.StatusBadRequest)
+		return
+	}
+	if settings.MinSeconds < 1 || settings.MaxGapSeconds < 0 {
+		http.Error(w, "Invalid session settings, MinSeconds must be positive and MaxGapSeconds not negative", http.StatusBadRequest)
+		return
+	}
+
+	changed, err := app.Repo.SetSessionSettings(tenantID(r), settings)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error storing session settings: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{ Changed bool }{changed})
+}
+
+// RefreshSessions finds the sessions around the activity written since the last refresh,
+// clients call it after syncing
+func (app *Config) RefreshSessions(w http.ResponseWriter, r *http.Request) {
+	n, err := app.Repo.RefreshSessions(tenantID(r))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error refreshing sessions: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{ Written int }{n})
+}
+
+// ListSessions returns the sessions overlapping ?start= to ?end=, the last seven days by
+// default, optionally of the ?device= only
+func (app *Config) ListSessions(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	start, end, err := parseRange(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sessions, err := app.Repo.ListSessions(tenantID(r), params.Get("device"), start, end)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading sessions: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if sessions == nil {
+		sessions = []data.Session{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/azaurus1/lifevisor-service/internal/data"
+)
+
+// defaultBucketTypes are the buckets the events of each summary come from unless ?bucket_type= says otherwise
+var defaultBucketTypes = map[string]string{
+	"app":      "currentwindow",
+	"title":    "currentwindow",
+	"url":      "web.tab.current",
+	"hostname": "currentwindow",
+	"category": "currentwindow",
+}
+
+// Summary returns the time spent per app, title, url, hostname or category in every day or hour between
+// ?start= and ?end=, with days starting at midnight in the ?tz= time zone. With ?active=true
+// only the time the user was not AFK is counted.
+func (app *Config) Summary(w http.ResponseWriter, r *http.Request) {
+	query, err := parseSummaryQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows, err := app.Repo.Summary(tenantID(r), query)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error summarising events: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if rows == nil {
+		rows = []data.SummaryRow{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rows)
+}
+
+func parseSummaryQuery(r *http.Request) (data.SummaryQuery, error) {
+	params := r.URL.Query()
+	query := data.SummaryQuery{
+		GroupBy:    params.Get("group_by"),
+		BucketType: params.Get("bucket_type"),
+		Resolution: params.Get("resolution"),
+		Location:   time.UTC,
+	}
+
+	if query.GroupBy == "" {
+		query.GroupBy = "app"
+	}
+	bucketType, ok := defaultBucketTypes[query.GroupBy]
+	if !ok {
+		return query, fmt.Errorf("Invalid group_by, expected app, title, url, hostname or category")
+	}
+	if query.BucketType == "" {
+		query.BucketType = bucketType
+	}
+
+	if query.Resolution == "" {
+		query.Resolution = "day"
+	}
+	if query.Resolution != "day" && query.Resolution != "hour" {
+		return query, fmt.Errorf("Invalid resolution, expected day or hour")
+	}
+
+	if value := params.Get("active"); value != "" {
+		active, err := strconv.ParseBool(value)
+		if err != nil {
+			return query, fmt.Errorf("Invalid active, expected true or false")
+		}
+		query.Active = active
+	}
+
+	if tz := params.Get("tz"); tz != "" {
+		location, err := time.LoadLocation(tz)
+		if err != nil {
+			return query, fmt.Errorf("Invalid tz, expected an IANA time zone such as Europe/Berlin: %v", err)
+		}
+		query.Location = location
+	}
+
+	// the last seven days up to now by default
+	now := time.Now().In(query.Location)
+	query.End = now
+	query.Start = time.Date(now.Year(), now.Month(), now.Day()-6, 0, 0, 0, 0, query.Location)
+
+	var err error
+	if value := params.Get("start"); value != "" {
+		query.Start, err = parseSummaryTime(value, query.Location)
+		if err != nil {
+			return query, fmt.Errorf("Invalid start: %v", err)
+		}
+	}
+	if value := params.Get("end"); value != "" {
+		query.End, err = parseSummaryTime(value, query.Location)
+		if err != nil {
+			return query, fmt.Errorf("Invalid end: %v", err)
+		}
+	}
+	if !query.Start.Before(query.End) {
+		return query, fmt.Errorf("Invalid range, start must be before end")
+	}
+
+	return query, nil
+}
+
+// parseSummaryTime takes an RFC 3339 time, or a date meaning midnight in location
+func parseSummaryTime(value string, location *time.Location) (time.Time, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, value, location); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return t, fmt.Errorf("expected an RFC 3339 time or a YYYY-MM-DD date")
+	}
+	return t, nil
+}
+
+// RefreshActive brings the active time of the tenant up to date, clients call it after syncing
+func (app *Config) RefreshActive(w http.ResponseWriter, r *http.Request) {
+	n, err := app.Repo.RefreshActiveEvents(tenantID(r))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error refreshing active time: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{ Written int }{n})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// RefreshSwitches finds the app switches among the events written since the last refresh,
+// clients call it after syncing
+func (app *Config) RefreshSwitches(w http.ResponseWriter, r *http.Request) {
+	n, err := app.Repo.RefreshAppSwitches(tenantID(r))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error refreshing app switches: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{ Written int }{n})
+}
+
+// Switches returns the app switches between ?start= and ?end= per hour of the day in the ?tz=
+// time zone, the last seven days by default, and the ?top= most frequent transitions
+func (app *Config) Switches(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	start, end, err := parseRange(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	top := 10
+	if value := params.Get("top"); value != "" {
+		top, err = strconv.Atoi(value)
+		if err != nil || top < 1 || top > 100 {
+			http.Error(w, "Invalid top, expected an integer between 1 and 100", http.StatusBadRequest)
+			return
+		}
+	}
+
+	report, err := app.Repo.Switches(tenantID(r), start, end, top)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading app switches: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
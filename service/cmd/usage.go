@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// GoalUsage returns the active seconds between ?start= and ?end=, the last seven days by default, spent on
+// ?target=, an app, site or category as ?kind= says. Clients check their goals with it after syncing.
+func (app *Config) GoalUsage(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	kind := params.Get("kind")
+	if kind != "app" && kind != "site" && kind != "category" {
+		http.Error(w, "Invalid kind, expected app, site or category", http.StatusBadRequest)
+		return
+	}
+	target := params.Get("target")
+	if target == "" {
+		http.Error(w, "Missing target query parameter", http.StatusBadRequest)
+		return
+	}
+
+	start, end, err := parseRange(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	seconds, err := app.Repo.GoalUsage(tenantID(r), kind, target, start, end)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading usage: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{ Seconds float64 }{seconds})
+}
@@ -12,6 +12,7 @@ type Models struct {
 }
 
 type Bucket struct {
+	DeviceID string
 	Key      int
 	ID       string
 	Created  time.Time
@@ -22,6 +23,7 @@ type Bucket struct {
 }
 
 type Event struct {
+	DeviceID  string
 	ID        int
 	BucketID  int
 	Timestamp time.Time
@@ -29,7 +31,196 @@ type Event struct {
 	DataStr   string
 }
 
+// WriteResult counts what an upsert did with the events it was given, the rest were already up to date
+type WriteResult struct {
+	Inserted int
+	Updated  int
+}
+
+func (r *WriteResult) count(inserted bool) {
+	if inserted {
+		r.Inserted++
+	} else {
+		r.Updated++
+	}
+}
+
+// SyncState is the high-water mark of a bucket in the destination
+type SyncState struct {
+	DeviceID      string
+	BucketKey     int
+	LastEventID   int
+	LastTimestamp time.Time
+}
+
+// EventQuery selects events of the buckets called BucketID, ordered by timestamp
+type EventQuery struct {
+	BucketID string
+	DeviceID string    // only events of this device, all devices when empty
+	Start    time.Time // inclusive, unbounded when zero
+	End      time.Time // exclusive, unbounded when zero
+	Limit    int
+	After    *EventCursor // continue after this event
+}
+
+// EventCursor is the position of an event in the order of EventQuery
+type EventCursor struct {
+	Timestamp time.Time
+	DeviceID  string
+	ID        int
+}
+
+// CategoryRule puts the window and tab events whose app, title or url match Regex into Category,
+// a path like "Work > Programming". When several rules match the deepest category wins.
+type CategoryRule struct {
+	Category   string
+	Regex      string // PostgreSQL regular expression
+	IgnoreCase bool
+}
+
+// Scoring weighs the active window time of categories from -1 (distracting) to 1 (productive),
+// categories without a weight take the one of their closest ancestor and 0 without any
+type Scoring struct {
+	Weights  map[string]float64
+	TimeZone string // IANA time zone days start at midnight in
+}
+
+// DailyScore is the active window time of a day and how productive it was
+type DailyScore struct {
+	Day         string // YYYY-MM-DD
+	Active      float64
+	Productive  float64 // seconds in categories weighted above 0
+	Distracting float64 // seconds in categories weighted below 0
+	Weighted    float64 // sum of seconds times weight
+	Score       float64 // 0 all distracting, 50 neutral, 100 all productive
+}
+
+// Usage is the active time spent on an app or site
+type Usage struct {
+	Key      string
+	Duration float64
+}
+
+// FocusStreak is the longest stretch of productive time
+type FocusStreak struct {
+	Start    time.Time
+	Duration float64
+}
+
+// Report scores a range of days and lists what the time went to
+type Report struct {
+	Start         time.Time
+	End           time.Time
+	Days          []DailyScore
+	TopApps       []Usage
+	TopSites      []Usage
+	LongestStreak *FocusStreak // nil without productive time
+}
+
+// SessionSettings say how sessions are found: runs of active window time of one category or
+// app (GroupBy) lasting at least MinSeconds and broken by nothing as long as MaxGapSeconds
+type SessionSettings struct {
+	GroupBy       string // category or app
+	MinSeconds    int
+	MaxGapSeconds int
+}
+
+// Session is a stretch of time on one device dominated by a category or app
+type Session struct {
+	DeviceID      string
+	Start         time.Time
+	End           time.Time
+	Key           string  // the dominant category or app
+	Focused       float64 // seconds spent on Key
+	Interruptions int     // windows of something else activated in between
+}
+
+// HourlySwitches are the app switches and active window time in one hour of the day
+type HourlySwitches struct {
+	Hour     int // 0 to 23
+	Switches int
+	Active   float64
+}
+
+// Transition counts the switches from one app to another
+type Transition struct {
+	From     string
+	To       string
+	Switches int
+}
+
+// SwitchReport is how often the focused app changed in a range of time
+type SwitchReport struct {
+	Start       time.Time
+	End         time.Time
+	Hours       []HourlySwitches
+	Transitions []Transition // most frequent first
+}
+
+// Total adds up the switches and active time of every hour
+func (r SwitchReport) Total() HourlySwitches {
+	var total HourlySwitches
+	for _, hour := range r.Hours {
+		total.Switches += hour.Switches
+		total.Active += hour.Active
+	}
+	return total
+}
+
+// PerHour is the number of switches per hour of active time
+func (h HourlySwitches) PerHour() float64 {
+	if h.Active == 0 {
+		return 0
+	}
+	return float64(h.Switches) / h.Active * 3600
+}
+
+// MeanInterval is the mean active time in seconds between two switches
+func (h HourlySwitches) MeanInterval() float64 {
+	if h.Switches == 0 {
+		return h.Active
+	}
+	return h.Active / float64(h.Switches)
+}
+
+// SummaryQuery totals the duration of events per period and per value of GroupBy
+type SummaryQuery struct {
+	GroupBy    string // app, title, url, hostname or category
+	BucketType string // only events of buckets of this type, e.g. currentwindow
+	Start      time.Time
+	End        time.Time
+	Resolution string         // day or hour
+	Location   *time.Location // periods start at midnight or on the hour here
+	Active     bool           // only count time the user was not AFK
+}
+
+// SummaryRow is the time spent on Key in the period starting at Period
+type SummaryRow struct {
+	Period   time.Time
+	Key      string
+	Duration float64 // seconds
+}
+
 func New(conn *pgxpool.Pool) *Models {
 	repo = NewPostgresRepository(conn)
 	return &Models{}
 }
+
+// Tenant is a user of the service, owning the data synced with their tokens
+type Tenant struct {
+	ID      int
+	Name    string
+	Created time.Time
+}
+
+// APIToken is a bearer token the service accepts, the token itself is only known to its holder
+type APIToken struct {
+	ID       int
+	TenantID int
+	Tenant   string // name of the tenant, only set by ListTokens and CreateToken
+	Name     string
+	Prefix   string
+	Created  time.Time
+	LastUsed *time.Time
+	Revoked  *time.Time
+}
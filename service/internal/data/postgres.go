@@ -2,8 +2,15 @@ package data
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"strconv"
+	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/stdlib"
 	migrate "github.com/rubenv/sql-migrate"
 )
@@ -33,27 +40,815 @@ func (u *PostgresRepository) RunMigrations() error {
 	return nil
 }
 
-func (u *PostgresRepository) InsertBucket(bucket Bucket) error {
+// inTenant runs fn in a transaction scoped to tenantID, inserts take the tenant from
+// the transaction and row-level security hides the rows of other tenants
+func (u *PostgresRepository) inTenant(ctx context.Context, tenantID int, fn func(tx pgx.Tx) error) error {
+	tx, err := u.Conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `select set_config('lifevisor.tenant_id', $1, true)`, strconv.Itoa(tenantID))
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+const insertBucketStmt = `insert into bucketmodel (device_id, key, id, created, name, type, client, hostname) values ($1, $2, $3, $4, $5, $6, $7, $8) on conflict (tenant_id, device_id, key) do nothing`
+
+func (u *PostgresRepository) InsertBucket(tenantID int, bucket Bucket) error {
+	ctx := context.Background()
+
+	return u.inTenant(ctx, tenantID, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, insertBucketStmt, bucket.DeviceID, bucket.Key, bucket.ID, bucket.Created, bucket.Name, bucket.Type, bucket.Client, bucket.Hostname)
+		return err
+	})
+}
+
+func (u *PostgresRepository) InsertEvent(tenantID int, event Event) error {
+	ctx := context.Background()
+
+	// heartbeats keep growing the duration of the latest event, so refresh rows that changed since the last push
+	stmt := `insert into eventmodel (device_id, id, bucket_id, timestamp, duration, datastr) values ($1, $2, $3, $4, $5, $6)
+	on conflict (tenant_id, device_id, id) do update set timestamp = excluded.timestamp, duration = excluded.duration, datastr = excluded.datastr
+	where (eventmodel.timestamp, eventmodel.duration, eventmodel.datastr::text) is distinct from (excluded.timestamp, excluded.duration, excluded.datastr::text)`
+	return u.inTenant(ctx, tenantID, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, stmt, event.DeviceID, event.ID, event.BucketID, event.Timestamp, event.Duration, event.DataStr)
+		return err
+	})
+}
+
+// InsertBuckets inserts the buckets in one transaction
+func (u *PostgresRepository) InsertBuckets(tenantID int, buckets []Bucket) error {
+	ctx := context.Background()
+
+	return u.inTenant(ctx, tenantID, func(tx pgx.Tx) error {
+		for _, bucket := range buckets {
+			_, err := tx.Exec(ctx, insertBucketStmt, bucket.DeviceID, bucket.Key, bucket.ID, bucket.Created, bucket.Name, bucket.Type, bucket.Client, bucket.Hostname)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// InsertEvents copies the events into a staging table and merges them into eventmodel in one transaction
+func (u *PostgresRepository) InsertEvents(tenantID int, events []Event) (WriteResult, error) {
+	ctx := context.Background()
+	var result WriteResult
+
+	err := u.inTenant(ctx, tenantID, func(tx pgx.Tx) error {
+		// the tenant column default fills in the tenant of the transaction
+		_, err := tx.Exec(ctx, `create temp table eventmodel_staging (like eventmodel including defaults) on commit drop`)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CopyFrom(ctx, pgx.Identifier{"eventmodel_staging"}, []string{"device_id", "id", "bucket_id", "timestamp", "duration", "datastr"},
+			pgx.CopyFromSlice(len(events), func(i int) ([]any, error) {
+				event := events[i]
+				return []any{event.DeviceID, event.ID, event.BucketID, event.Timestamp, event.Duration, event.DataStr}, nil
+			}))
+		if err != nil {
+			return err
+		}
+
+		// same merge as InsertEvent, distinct on the key since one batch may carry an event twice
+		stmt := `insert into eventmodel (tenant_id, device_id, id, bucket_id, timestamp, duration, datastr)
+		select distinct on (device_id, id) tenant_id, device_id, id, bucket_id, timestamp, duration, datastr from eventmodel_staging order by device_id, id
+		on conflict (tenant_id, device_id, id) do update set timestamp = excluded.timestamp, duration = excluded.duration, datastr = excluded.datastr
+		where (eventmodel.timestamp, eventmodel.duration, eventmodel.datastr::text) is distinct from (excluded.timestamp, excluded.duration, excluded.datastr::text)
+		returning (xmax = 0)`
+		rows, err := tx.Query(ctx, stmt)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		// xmax is only set on rows that already existed, unchanged rows are not returned at all
+		for rows.Next() {
+			var inserted bool
+			err := rows.Scan(&inserted)
+			if err != nil {
+				return err
+			}
+			result.count(inserted)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return WriteResult{}, err
+	}
+
+	return result, nil
+}
+
+func (u *PostgresRepository) GetSyncStates(tenantID int, deviceID string) (map[int]SyncState, error) {
+	ctx := context.Background()
+
+	states := make(map[int]SyncState)
+	err := u.inTenant(ctx, tenantID, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `select device_id, bucket_key, last_event_id, last_timestamp from syncstate where tenant_id = $1 and device_id = $2`, tenantID, deviceID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var state SyncState
+			err := rows.Scan(&state.DeviceID, &state.BucketKey, &state.LastEventID, &state.LastTimestamp)
+			if err != nil {
+				return err
+			}
+			states[state.BucketKey] = state
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return states, nil
+}
+
+func (u *PostgresRepository) UpdateSyncState(tenantID int, state SyncState) error {
+	ctx := context.Background()
+
+	stmt := `insert into syncstate (device_id, bucket_key, last_event_id, last_timestamp, updated) values ($1, $2, $3, $4, now())
+	on conflict (tenant_id, device_id, bucket_key) do update set last_event_id = excluded.last_event_id, last_timestamp = excluded.last_timestamp, updated = now()
+	where syncstate.last_event_id <= excluded.last_event_id`
+	return u.inTenant(ctx, tenantID, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, stmt, state.DeviceID, state.BucketKey, state.LastEventID, state.LastTimestamp)
+		return err
+	})
+}
+
+// ListBuckets returns the buckets of deviceID, or of every device when it is empty
+func (u *PostgresRepository) ListBuckets(tenantID int, deviceID string) ([]Bucket, error) {
+	ctx := context.Background()
+
+	var buckets []Bucket
+	err := u.inTenant(ctx, tenantID, func(tx pgx.Tx) error {
+		stmt := `select device_id, key, id, created, name, type, client, hostname from bucketmodel
+		where tenant_id = $1 and ($2 = '' or device_id = $2) order by id, device_id`
+		rows, err := tx.Query(ctx, stmt, tenantID, deviceID)
+		if err != nil {
+			return err
+		}
+
+		buckets, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (Bucket, error) {
+			var bucket Bucket
+			err := row.Scan(&bucket.DeviceID, &bucket.Key, &bucket.ID, &bucket.Created, &bucket.Name, &bucket.Type, &bucket.Client, &bucket.Hostname)
+			return bucket, err
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return buckets, nil
+}
+
+// ErrBucketNotFound is returned when listing the events of a bucket that does not exist
+var ErrBucketNotFound = errors.New("bucket not found")
+
+// ListEvents returns up to query.Limit events matching query, continuing after query.After
+func (u *PostgresRepository) ListEvents(tenantID int, query EventQuery) ([]Event, error) {
+	ctx := context.Background()
+
+	// nil leaves a bound out
+	var start, end, afterTimestamp *time.Time
+	if !query.Start.IsZero() {
+		t := query.Start.UTC()
+		start = &t
+	}
+	if !query.End.IsZero() {
+		t := query.End.UTC()
+		end = &t
+	}
+	var afterDevice string
+	var afterID int
+	if query.After != nil {
+		t := query.After.Timestamp.UTC()
+		afterTimestamp = &t
+		afterDevice = query.After.DeviceID
+		afterID = query.After.ID
+	}
+
+	var events []Event
+	err := u.inTenant(ctx, tenantID, func(tx pgx.Tx) error {
+		var exists bool
+		err := tx.QueryRow(ctx, `select exists (select 1 from bucketmodel where tenant_id = $1 and id = $2 and ($3 = '' or device_id = $3))`,
+			tenantID, query.BucketID, query.DeviceID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrBucketNotFound
+		}
+
+		// keyset pagination on (timestamp, device_id, id), the primary key makes the order total
+		stmt := `select e.device_id, e.id, e.bucket_id, e.timestamp, e.duration, e.datastr::text
+		from eventmodel e join bucketmodel b on b.tenant_id = e.tenant_id and b.device_id = e.device_id and b.key = e.bucket_id
+		where e.tenant_id = $1 and b.id = $2 and ($3 = '' or e.device_id = $3)
+		and ($4::timestamp is null or e.timestamp >= $4) and ($5::timestamp is null or e.timestamp < $5)
+		and ($6::timestamp is null or (e.timestamp, e.device_id, e.id) > ($6, $7, $8))
+		order by e.timestamp, e.device_id, e.id
+		limit $9`
+		rows, err := tx.Query(ctx, stmt, tenantID, query.BucketID, query.DeviceID, start, end, afterTimestamp, afterDevice, afterID, query.Limit)
+		if err != nil {
+			return err
+		}
+
+		events, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (Event, error) {
+			var event Event
+			err := row.Scan(&event.DeviceID, &event.ID, &event.BucketID, &event.Timestamp, &event.Duration, &event.DataStr)
+			return event, err
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// summaryKeys are the expressions Summary groups by, keyed by SummaryQuery.GroupBy
+var summaryKeys = map[string]string{
+	"app":      `e.datastr->>'app'`,
+	"title":    `e.datastr->>'title'`,
+	"url":      `e.datastr->>'url'`,
+	"hostname": `b.hostname`,
+	"category": `(select c.category from event_category c
+		where c.tenant_id = e.tenant_id and c.device_id = e.device_id and c.event_id = e.event_id)`,
+}
+
+// Summary totals the time spent per period and key. Events crossing a period boundary, or the
+// bounds of the query, are split and only their overlap is counted.
+func (u *PostgresRepository) Summary(tenantID int, query SummaryQuery) ([]SummaryRow, error) {
 	ctx := context.Background()
 
-	stmt := `insert into bucketmodel (key, id, created, name, type, client, hostname) values ($1, $2, $3, $4, $5, $6, $7) on conflict (key) do nothing`
-	_, err := u.Conn.Exec(ctx, stmt, bucket.Key, bucket.ID, bucket.Created, bucket.Name, bucket.Type, bucket.Client, bucket.Hostname)
+	key, ok := summaryKeys[query.GroupBy]
+	if !ok {
+		return nil, fmt.Errorf("cannot group by %q", query.GroupBy)
+	}
+	if query.Resolution != "day" && query.Resolution != "hour" {
+		return nil, fmt.Errorf("unknown resolution %q", query.Resolution)
+	}
+	location := query.Location
+	if location == nil {
+		location = time.UTC
+	}
+
+	// active time comes from the parts of the events overlapping not-afk periods
+	spans := `select e.tenant_id, e.device_id, e.id as event_id, e.bucket_id, e.timestamp, e.duration, e.datastr from eventmodel e`
+	if query.Active {
+		spans = `select a.tenant_id, a.device_id, a.event_id, e.bucket_id, a.timestamp, a.duration, e.datastr from activeevent a
+		join eventmodel e on e.tenant_id = a.tenant_id and e.device_id = a.device_id and e.id = a.event_id`
+	}
+
+	// eventmodel.timestamp is UTC, periods are truncated in local time and turned back into
+	// instants so days shortened or lengthened by DST are counted by their real length
+	stmt := `with ev as (
+		select coalesce(` + key + `, '') as key,
+			e.timestamp at time zone 'UTC' as s,
+			(e.timestamp + e.duration * interval '1 second') at time zone 'UTC' as f
+		from (` + spans + `) e join bucketmodel b on b.tenant_id = e.tenant_id and b.device_id = e.device_id and b.key = e.bucket_id
+		where e.tenant_id = $1 and b.type = $2
+		and e.timestamp < ($4::timestamptz at time zone 'UTC')
+		and e.timestamp + e.duration * interval '1 second' > ($3::timestamptz at time zone 'UTC')
+	), parts as (
+		select ev.key, p.local as period,
+			extract(epoch from least(ev.f, (p.local + $6::interval) at time zone $7, $4::timestamptz)
+				- greatest(ev.s, p.local at time zone $7, $3::timestamptz)) as seconds
+		from ev cross join lateral generate_series(date_trunc($5, ev.s at time zone $7), ev.f at time zone $7, $6::interval) as p (local)
+	)
+	select period, key, sum(seconds)::float8 from parts where seconds > 0
+	group by period, key order by period, sum(seconds) desc`
+
+	var rows []SummaryRow
+	err := u.inTenant(ctx, tenantID, func(tx pgx.Tx) error {
+		if query.Active {
+			// in case a client did not refresh after its last sync
+			_, err := tx.Exec(ctx, `select refresh_active_events()`)
+			if err != nil {
+				return err
+			}
+		}
+		if query.GroupBy == "category" {
+			_, err := tx.Exec(ctx, `select refresh_event_categories()`)
+			if err != nil {
+				return err
+			}
+		}
+
+		result, err := tx.Query(ctx, stmt, tenantID, query.BucketType, query.Start, query.End, query.Resolution, "1 "+query.Resolution, location.String())
+		if err != nil {
+			return err
+		}
+
+		rows, err = pgx.CollectRows(result, func(row pgx.CollectableRow) (SummaryRow, error) {
+			var summary SummaryRow
+			var period time.Time
+			err := row.Scan(&period, &summary.Key, &summary.Duration)
+			// period is a wall clock time in location
+			summary.Period = time.Date(period.Year(), period.Month(), period.Day(), period.Hour(), 0, 0, 0, location)
+			return summary, err
+		})
+		return err
+	})
 	if err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// ErrInvalidRegex is returned when a category rule has a regex PostgreSQL cannot compile
+var ErrInvalidRegex = errors.New("invalid regular expression")
+
+// SetCategoryRules replaces the categorisation rules of the tenant, reporting whether they changed.
+// Changed rules mark every event for categorising again on the next refresh.
+func (u *PostgresRepository) SetCategoryRules(tenantID int, rules []CategoryRule) (bool, error) {
+	ctx := context.Background()
+
+	if rules == nil {
+		rules = []CategoryRule{}
+	}
+	payload, err := json.Marshal(rules)
+	if err != nil {
+		return false, err
+	}
+
+	var changed bool
+	err = u.inTenant(ctx, tenantID, func(tx pgx.Tx) error {
+		return tx.QueryRow(ctx, `select set_category_rules($1::jsonb)`, string(payload)).Scan(&changed)
+	})
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "2201B" {
+		return false, fmt.Errorf("%w: %s", ErrInvalidRegex, pgErr.Message)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return changed, nil
+}
+
+// ListCategoryRules returns the categorisation rules of the tenant in the order they apply
+func (u *PostgresRepository) ListCategoryRules(tenantID int) ([]CategoryRule, error) {
+	ctx := context.Background()
+
+	var rules []CategoryRule
+	err := u.inTenant(ctx, tenantID, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `select category, regex, ignore_case from category_rule where tenant_id = $1 order by position`, tenantID)
+		if err != nil {
+			return err
+		}
+
+		rules, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (CategoryRule, error) {
+			var rule CategoryRule
+			err := row.Scan(&rule.Category, &rule.Regex, &rule.IgnoreCase)
+			return rule, err
+		})
 		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	return rules, nil
+}
+
+// RefreshEventCategories categorises the events written since the last refresh
+func (u *PostgresRepository) RefreshEventCategories(tenantID int) (int, error) {
+	ctx := context.Background()
+
+	var n int
+	err := u.inTenant(ctx, tenantID, func(tx pgx.Tx) error {
+		return tx.QueryRow(ctx, `select refresh_event_categories()`).Scan(&n)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// RecategorizeEvents categorises every event of the tenant again with its current rules
+func (u *PostgresRepository) RecategorizeEvents(tenantID int) (int, error) {
+	ctx := context.Background()
+
+	var n int
+	err := u.inTenant(ctx, tenantID, func(tx pgx.Tx) error {
+		return tx.QueryRow(ctx, `select recategorize_events()`).Scan(&n)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// SetScoring replaces the category weights and the time zone of the daily scores of the tenant,
+// reporting whether they changed. Changes score every day again on the next refresh.
+func (u *PostgresRepository) SetScoring(tenantID int, scoring Scoring) (bool, error) {
+	ctx := context.Background()
+
+	weights := scoring.Weights
+	if weights == nil {
+		weights = map[string]float64{}
+	}
+	payload, err := json.Marshal(weights)
+	if err != nil {
+		return false, err
+	}
+
+	var changed bool
+	err = u.inTenant(ctx, tenantID, func(tx pgx.Tx) error {
+		return tx.QueryRow(ctx, `select set_scoring($1::jsonb, $2)`, string(payload), scoring.TimeZone).Scan(&changed)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return changed, nil
+}
+
+// RefreshDailyScores scores the days whose active time or categories changed since the last refresh
+func (u *PostgresRepository) RefreshDailyScores(tenantID int) (int, error) {
+	ctx := context.Background()
+
+	var n int
+	err := u.inTenant(ctx, tenantID, func(tx pgx.Tx) error {
+		return tx.QueryRow(ctx, `select refresh_daily_scores()`).Scan(&n)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// execer is a pool, a connection or a transaction
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// refresh runs the refresh functions named in order through q, catching up on the derived
+// tables clients have not refreshed before reading them
+func refresh(ctx context.Context, q execer, functions ...string) error {
+	for _, function := range functions {
+		_, err := q.Exec(ctx, `select `+function+`()`)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
+}
+
+// Report scores the days from start to end, dates taken in the location of start, and lists the
+// top apps and sites in between
+func (u *PostgresRepository) Report(tenantID int, start, end time.Time, top int) (Report, error) {
+	ctx := context.Background()
+	report := Report{Start: start, End: end}
+
+	err := u.inTenant(ctx, tenantID, func(tx pgx.Tx) error {
+		// 1. catch up
+		err := refresh(ctx, tx, "refresh_active_events", "refresh_event_categories", "refresh_daily_scores")
+		if err != nil {
+			return err
+		}
 
+		// 2. the days, end is exclusive
+		lastDay := end.Add(-time.Nanosecond).In(start.Location())
+		rows, err := tx.Query(ctx, `select day::text, active_seconds, productive_seconds, distracting_seconds, weighted_seconds, score
+		from daily_scores where tenant_id = $1 and day between $2::date and $3::date order by day`,
+			tenantID, start.Format(time.DateOnly), lastDay.Format(time.DateOnly))
+		if err != nil {
+			return err
+		}
+		report.Days, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (DailyScore, error) {
+			var day DailyScore
+			err := row.Scan(&day.Day, &day.Active, &day.Productive, &day.Distracting, &day.Weighted, &day.Score)
+			return day, err
+		})
+		if err != nil {
+			return err
+		}
+
+		// 3. top apps and sites
+		topActive := func(bucketType string) ([]Usage, error) {
+			rows, err := tx.Query(ctx, `select key, seconds from top_active($1, $2, $3, $4)`, bucketType, start, end, top)
+			if err != nil {
+				return nil, err
+			}
+			return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Usage, error) {
+				var usage Usage
+				err := row.Scan(&usage.Key, &usage.Duration)
+				return usage, err
+			})
+		}
+		report.TopApps, err = topActive("currentwindow")
+		if err != nil {
+			return err
+		}
+		report.TopSites, err = topActive("web.tab.current")
+		if err != nil {
+			return err
+		}
+
+		// 4. longest streak
+		var streak FocusStreak
+		err = tx.QueryRow(ctx, `select started, seconds from longest_focus_streak($1, $2)`, start, end).Scan(&streak.Start, &streak.Duration)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		report.LongestStreak = &streak
+		return nil
+	})
+	if err != nil {
+		return Report{}, err
+	}
+
+	return report, nil
 }
 
-func (u *PostgresRepository) InsertEvent(event Event) error {
+// SetSessionSettings replaces how the sessions of the tenant are found, reporting whether that
+// changed. Changes find every session again on the next refresh.
+func (u *PostgresRepository) SetSessionSettings(tenantID int, settings SessionSettings) (bool, error) {
 	ctx := context.Background()
 
-	stmt := `insert into eventmodel (id, bucket_id, timestamp, duration, datastr) values ($1, $2, $3, $4, $5) on conflict (id) do nothing`
-	_, err := u.Conn.Exec(ctx, stmt, event.ID, event.BucketID, event.Timestamp, event.Duration, event.DataStr)
+	var changed bool
+	err := u.inTenant(ctx, tenantID, func(tx pgx.Tx) error {
+		return tx.QueryRow(ctx, `select set_session_settings($1, $2, $3)`, settings.GroupBy, settings.MinSeconds, settings.MaxGapSeconds).Scan(&changed)
+	})
 	if err != nil {
+		return false, err
+	}
+
+	return changed, nil
+}
+
+// RefreshSessions finds the sessions around the activity changed since the last refresh
+func (u *PostgresRepository) RefreshSessions(tenantID int) (int, error) {
+	ctx := context.Background()
+
+	var n int
+	err := u.inTenant(ctx, tenantID, func(tx pgx.Tx) error {
+		return tx.QueryRow(ctx, `select refresh_sessions()`).Scan(&n)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// ListSessions returns the sessions overlapping start to end, oldest first, of every device
+// unless deviceID is set
+func (u *PostgresRepository) ListSessions(tenantID int, deviceID string, start, end time.Time) ([]Session, error) {
+	ctx := context.Background()
+
+	var sessions []Session
+	err := u.inTenant(ctx, tenantID, func(tx pgx.Tx) error {
+		err := refresh(ctx, tx, "refresh_active_events", "refresh_event_categories", "refresh_sessions")
+		if err != nil {
+			return err
+		}
+
+		// sessions.started is UTC
+		rows, err := tx.Query(ctx, `select device_id, started, ended, key, focused_seconds, interruptions
+		from sessions where tenant_id = $1 and ($2 = '' or device_id = $2)
+		and started < ($4::timestamptz at time zone 'UTC') and ended > ($3::timestamptz at time zone 'UTC')
+		order by started, device_id`, tenantID, deviceID, start, end)
+		if err != nil {
+			return err
+		}
+
+		sessions, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (Session, error) {
+			var session Session
+			err := row.Scan(&session.DeviceID, &session.Start, &session.End, &session.Key, &session.Focused, &session.Interruptions)
+			return session, err
+		})
 		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// RefreshAppSwitches finds the app switches among the window events written since the last refresh
+func (u *PostgresRepository) RefreshAppSwitches(tenantID int) (int, error) {
+	ctx := context.Background()
+
+	var n int
+	err := u.inTenant(ctx, tenantID, func(tx pgx.Tx) error {
+		return tx.QueryRow(ctx, `select refresh_app_switches()`).Scan(&n)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// Switches counts the app switches from start to end per hour of the day in the location of
+// start, and lists the top most frequent transitions
+func (u *PostgresRepository) Switches(tenantID int, start, end time.Time, top int) (SwitchReport, error) {
+	ctx := context.Background()
+	report := SwitchReport{Start: start, End: end}
+
+	err := u.inTenant(ctx, tenantID, func(tx pgx.Tx) error {
+		// 1. catch up
+		err := refresh(ctx, tx, "refresh_active_events", "refresh_app_switches")
+		if err != nil {
+			return err
+		}
+
+		// 2. per hour of the day
+		rows, err := tx.Query(ctx, `select hour, switches, active_seconds from switches_by_hour($1, $2, $3)`, start, end, start.Location().String())
+		if err != nil {
+			return err
+		}
+		report.Hours, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (HourlySwitches, error) {
+			var hour HourlySwitches
+			err := row.Scan(&hour.Hour, &hour.Switches, &hour.Active)
+			return hour, err
+		})
+		if err != nil {
+			return err
+		}
+
+		// 3. transitions
+		rows, err = tx.Query(ctx, `select from_app, to_app, switches from top_transitions($1, $2, $3)`, start, end, top)
+		if err != nil {
+			return err
+		}
+		report.Transitions, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (Transition, error) {
+			var transition Transition
+			err := row.Scan(&transition.From, &transition.To, &transition.Switches)
+			return transition, err
+		})
+		return err
+	})
+	if err != nil {
+		return SwitchReport{}, err
+	}
+
+	return report, nil
+}
+
+// GoalUsage returns the active seconds from start to end the tenant spent on target, an app,
+// site or category depending on kind
+func (u *PostgresRepository) GoalUsage(tenantID int, kind, target string, start, end time.Time) (float64, error) {
+	ctx := context.Background()
+
+	var seconds float64
+	err := u.inTenant(ctx, tenantID, func(tx pgx.Tx) error {
+		err := refresh(ctx, tx, "refresh_active_events", "refresh_event_categories")
+		if err != nil {
+			return err
+		}
+
+		return tx.QueryRow(ctx, `select goal_usage($1, $2, $3, $4)`, kind, target, start, end).Scan(&seconds)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return seconds, nil
+}
+
+// RefreshActiveEvents recomputes the active time of the events written since the last refresh
+func (u *PostgresRepository) RefreshActiveEvents(tenantID int) (int, error) {
+	ctx := context.Background()
+
+	var n int
+	err := u.inTenant(ctx, tenantID, func(tx pgx.Tx) error {
+		return tx.QueryRow(ctx, `select refresh_active_events()`).Scan(&n)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+func (u *PostgresRepository) CreateTenant(name string) (Tenant, error) {
+	ctx := context.Background()
+
+	tenant := Tenant{Name: name}
+	stmt := `insert into tenant (name) values ($1) returning id, created`
+	err := u.Conn.QueryRow(ctx, stmt, name).Scan(&tenant.ID, &tenant.Created)
+	if err != nil {
+		return Tenant{}, err
+	}
+
+	return tenant, nil
+}
+
+func (u *PostgresRepository) ListTenants() ([]Tenant, error) {
+	ctx := context.Background()
+
+	rows, err := u.Conn.Query(ctx, `select id, name, created from tenant order by id`)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Tenant, error) {
+		var tenant Tenant
+		err := row.Scan(&tenant.ID, &tenant.Name, &tenant.Created)
+		return tenant, err
+	})
+}
+
+// ErrTenantNotFound is returned when creating a token for a tenant that does not exist
+var ErrTenantNotFound = errors.New("tenant not found")
+
+// CreateToken stores the hash of a new token of the tenant called tenantName
+func (u *PostgresRepository) CreateToken(tenantName, name, prefix, hash string) (APIToken, error) {
+	ctx := context.Background()
+
+	token := APIToken{Name: name, Prefix: prefix, Tenant: tenantName}
+	stmt := `insert into apitoken (tenant_id, name, prefix, token_hash) select id, $2, $3, $4 from tenant where name = $1
+	returning id, tenant_id, created`
+	err := u.Conn.QueryRow(ctx, stmt, tenantName, name, prefix, hash).Scan(&token.ID, &token.TenantID, &token.Created)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return APIToken{}, ErrTenantNotFound
+	}
+	if err != nil {
+		return APIToken{}, err
+	}
+
+	return token, nil
+}
+
+// ErrTokenNotFound is returned for tokens that do not exist or are revoked already
+var ErrTokenNotFound = errors.New("token not found")
+
+func (u *PostgresRepository) RevokeToken(id int) error {
+	ctx := context.Background()
+
+	stmt := `update apitoken set revoked = now() where id = $1 and revoked is null`
+	tag, err := u.Conn.Exec(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrTokenNotFound
 	}
 
 	return nil
 }
+
+func (u *PostgresRepository) ListTokens() ([]APIToken, error) {
+	ctx := context.Background()
+
+	stmt := `select apitoken.id, tenant_id, tenant.name, apitoken.name, prefix, apitoken.created, last_used, revoked
+	from apitoken join tenant on tenant.id = apitoken.tenant_id order by apitoken.id`
+	rows, err := u.Conn.Query(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (APIToken, error) {
+		var token APIToken
+		err := row.Scan(&token.ID, &token.TenantID, &token.Tenant, &token.Name, &token.Prefix, &token.Created, &token.LastUsed, &token.Revoked)
+		return token, err
+	})
+}
+
+// AuthenticateToken returns the active token with the given hash and records that it was used
+func (u *PostgresRepository) AuthenticateToken(hash string) (APIToken, error) {
+	ctx := context.Background()
+
+	var token APIToken
+	stmt := `update apitoken set last_used = now() where token_hash = $1 and revoked is null
+	returning id, tenant_id, name, prefix, created, last_used`
+	err := u.Conn.QueryRow(ctx, stmt, hash).Scan(&token.ID, &token.TenantID, &token.Name, &token.Prefix, &token.Created, &token.LastUsed)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return APIToken{}, ErrTokenNotFound
+	}
+	if err != nil {
+		return APIToken{}, err
+	}
+
+	return token, nil
+}
@@ -1,13 +1,42 @@
 package data
 
 import (
+	"time"
+
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Repository interface {
 	RunMigrations() error
-	InsertBucket(bucket Bucket) error
-	InsertEvent(event Event) error
+	InsertBucket(tenantID int, bucket Bucket) error
+	InsertBuckets(tenantID int, buckets []Bucket) error
+	InsertEvent(tenantID int, event Event) error
+	InsertEvents(tenantID int, events []Event) (WriteResult, error)
+	GetSyncStates(tenantID int, deviceID string) (map[int]SyncState, error)
+	UpdateSyncState(tenantID int, state SyncState) error
+	ListBuckets(tenantID int, deviceID string) ([]Bucket, error)
+	ListEvents(tenantID int, query EventQuery) ([]Event, error)
+	Summary(tenantID int, query SummaryQuery) ([]SummaryRow, error)
+	RefreshActiveEvents(tenantID int) (int, error)
+	SetCategoryRules(tenantID int, rules []CategoryRule) (bool, error)
+	ListCategoryRules(tenantID int) ([]CategoryRule, error)
+	RefreshEventCategories(tenantID int) (int, error)
+	RecategorizeEvents(tenantID int) (int, error)
+	SetScoring(tenantID int, scoring Scoring) (bool, error)
+	RefreshDailyScores(tenantID int) (int, error)
+	Report(tenantID int, start, end time.Time, top int) (Report, error)
+	SetSessionSettings(tenantID int, settings SessionSettings) (bool, error)
+	RefreshSessions(tenantID int) (int, error)
+	ListSessions(tenantID int, deviceID string, start, end time.Time) ([]Session, error)
+	RefreshAppSwitches(tenantID int) (int, error)
+	Switches(tenantID int, start, end time.Time, top int) (SwitchReport, error)
+	GoalUsage(tenantID int, kind, target string, start, end time.Time) (float64, error)
+	CreateTenant(name string) (Tenant, error)
+	ListTenants() ([]Tenant, error)
+	CreateToken(tenantName, name, prefix, hash string) (APIToken, error)
+	RevokeToken(id int) error
+	ListTokens() ([]APIToken, error)
+	AuthenticateToken(hash string) (APIToken, error)
 }
 
 var repo Repository
@@ -0,0 +1,48 @@
+-- +migrate Up
+-- Scope buckets, events and watermarks to the device they were synced from,
+-- rows synced before devices existed belong to the 'legacy' device
+ALTER TABLE syncstate DROP CONSTRAINT syncstate_bucket_key_fkey;
+ALTER TABLE eventmodel DROP CONSTRAINT eventmodel_bucket_id_fkey;
+
+ALTER TABLE bucketmodel ADD COLUMN device_id TEXT NOT NULL DEFAULT 'legacy';
+ALTER TABLE eventmodel ADD COLUMN device_id TEXT NOT NULL DEFAULT 'legacy';
+ALTER TABLE syncstate ADD COLUMN device_id TEXT NOT NULL DEFAULT 'legacy';
+
+ALTER TABLE bucketmodel ALTER COLUMN device_id DROP DEFAULT;
+ALTER TABLE eventmodel ALTER COLUMN device_id DROP DEFAULT;
+ALTER TABLE syncstate ALTER COLUMN device_id DROP DEFAULT;
+
+-- Keys are copied from each device's local SQLite, so they are only unique per device
+ALTER TABLE bucketmodel DROP CONSTRAINT bucketmodel_pkey;
+ALTER TABLE bucketmodel DROP CONSTRAINT bucketmodel_id_key;
+ALTER TABLE bucketmodel ADD PRIMARY KEY (device_id, key);
+ALTER TABLE bucketmodel ADD UNIQUE (device_id, id);
+
+ALTER TABLE eventmodel DROP CONSTRAINT eventmodel_pkey;
+ALTER TABLE eventmodel ADD PRIMARY KEY (device_id, id);
+ALTER TABLE eventmodel ADD FOREIGN KEY (device_id, bucket_id) REFERENCES bucketmodel (device_id, key) ON DELETE CASCADE;
+
+ALTER TABLE syncstate DROP CONSTRAINT syncstate_pkey;
+ALTER TABLE syncstate ADD PRIMARY KEY (device_id, bucket_key);
+ALTER TABLE syncstate ADD FOREIGN KEY (device_id, bucket_key) REFERENCES bucketmodel (device_id, key) ON DELETE CASCADE;
+
+-- +migrate Down
+ALTER TABLE syncstate DROP CONSTRAINT syncstate_device_id_bucket_key_fkey;
+ALTER TABLE eventmodel DROP CONSTRAINT eventmodel_device_id_bucket_id_fkey;
+
+ALTER TABLE syncstate DROP CONSTRAINT syncstate_pkey;
+ALTER TABLE syncstate ADD PRIMARY KEY (bucket_key);
+ALTER TABLE syncstate DROP COLUMN device_id;
+
+ALTER TABLE eventmodel DROP CONSTRAINT eventmodel_pkey;
+ALTER TABLE eventmodel ADD PRIMARY KEY (id);
+ALTER TABLE eventmodel DROP COLUMN device_id;
+
+ALTER TABLE bucketmodel DROP CONSTRAINT bucketmodel_device_id_id_key;
+ALTER TABLE bucketmodel DROP CONSTRAINT bucketmodel_pkey;
+ALTER TABLE bucketmodel ADD PRIMARY KEY (key);
+ALTER TABLE bucketmodel ADD UNIQUE (id);
+ALTER TABLE bucketmodel DROP COLUMN device_id;
+
+ALTER TABLE eventmodel ADD FOREIGN KEY (bucket_id) REFERENCES bucketmodel (key) ON DELETE CASCADE;
+ALTER TABLE syncstate ADD FOREIGN KEY (bucket_key) REFERENCES bucketmodel (key) ON DELETE CASCADE;
@@ -0,0 +1,14 @@
+-- +migrate Up
+-- Bearer tokens accepted by lifevisor-service, only a SHA-256 hash of each token is stored
+CREATE TABLE apitoken (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL, -- What the token is for, e.g. the machine using it
+    prefix TEXT NOT NULL, -- First characters of the token, to recognise it in listings
+    token_hash TEXT NOT NULL UNIQUE, -- Hex SHA-256 of the whole token
+    created TIMESTAMP NOT NULL DEFAULT NOW (),
+    last_used TIMESTAMP,
+    revoked TIMESTAMP -- Set once the token is revoked, revoked tokens are rejected
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS apitoken;